@@ -2,6 +2,7 @@ package caddy_nats_bridge
 
 import (
 	"github.com/CoverWhale/caddy-nats-bridge/body_jetstream"
+	"github.com/CoverWhale/caddy-nats-bridge/health"
 	"github.com/CoverWhale/caddy-nats-bridge/logoutput"
 	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
 	"github.com/CoverWhale/caddy-nats-bridge/publish"
@@ -15,6 +16,7 @@ func init() {
 	caddy.RegisterModule(natsbridge.NatsBridgeApp{})
 	httpcaddyfile.RegisterGlobalOption("nats", natsbridge.ParseGobalNatsOption)
 	caddy.RegisterModule(subscribe.Subscribe{})
+	caddy.RegisterModule(natsbridge.AdminAPI{})
 
 	caddy.RegisterModule(publish.Publish{})
 	httpcaddyfile.RegisterHandlerDirective("nats_publish", publish.ParsePublishHandler)
@@ -22,6 +24,9 @@ func init() {
 	caddy.RegisterModule(request.Request{})
 	httpcaddyfile.RegisterHandlerDirective("nats_request", request.ParseRequestHandler)
 
+	caddy.RegisterModule(health.Health{})
+	httpcaddyfile.RegisterHandlerDirective("nats_health", health.ParseHealthHandler)
+
 	// store request body to Jetstream
 	caddy.RegisterModule(body_jetstream.StoreBodyToJetStream{})
 	httpcaddyfile.RegisterHandlerDirective("store_body_to_jetstream", body_jetstream.ParseStoreBodyToJetstream)
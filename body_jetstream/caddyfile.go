@@ -4,6 +4,7 @@ import (
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
 	"time"
 )
 
@@ -11,6 +12,8 @@ import (
 //
 //	store_body_to_jetstream [<matcher>] [bucketName] {
 //	    [ttl 5m]
+//	    [max_size 100MiB]
+//	    [min_size 512KiB|auto]
 //	}
 func ParseStoreBodyToJetstream(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var sb = StoreBodyToJetStream{
@@ -42,6 +45,28 @@ func ParseStoreBodyToJetstream(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHand
 					return nil, h.Err("TTL is not a valid duration")
 				}
 				sb.TTL = ttl
+			case "max_size":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				size, err := humanize.ParseBytes(h.Val())
+				if err != nil {
+					return nil, h.Errf("max_size %s is not a valid size: %v", h.Val(), err)
+				}
+				sb.MaxSize = int64(size)
+			case "min_size":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				if h.Val() == "auto" {
+					sb.MinSizeAuto = true
+					continue
+				}
+				size, err := humanize.ParseBytes(h.Val())
+				if err != nil {
+					return nil, h.Errf("min_size %s is not a valid size: %v", h.Val(), err)
+				}
+				sb.MinSize = int64(size)
 			default:
 				return nil, h.Errf("unrecognized subdirective: %s", h.Val())
 			}
@@ -2,6 +2,7 @@ package body_jetstream
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,9 +23,19 @@ type StoreBodyToJetStream struct {
 	TTL    time.Duration `json:"ttl,omitempty"`
 	// in which NATS server should the request body be stored?
 	ServerAlias string `json:"serverAlias,omitempty"`
-
-	app    *natsbridge.NatsBridgeApp
-	logger *zap.Logger
+	// MaxSize is the maximum size of a request body in bytes; larger bodies are answered with 413. Unlimited if
+	// not set.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// MinSize is the size in bytes from which on a request body is stored; smaller bodies stay inline in the NATS
+	// message. If not set, all non-empty bodies are stored.
+	MinSize int64 `json:"minSize,omitempty"`
+	// MinSizeAuto stores only bodies which would not fit into a NATS message, based on the max payload of the
+	// connection.
+	MinSizeAuto bool `json:"minSizeAuto,omitempty"`
+
+	app     *natsbridge.NatsBridgeApp
+	logger  *zap.Logger
+	metrics *common.Metrics
 	// do not use directly, but always use objectStore() to access, to ensure it is initialized.
 	os atomic.Pointer[nats.ObjectStore]
 }
@@ -44,54 +55,146 @@ func (sb *StoreBodyToJetStream) Provision(ctx caddy.Context) error {
 		return fmt.Errorf("getting NATS app: %w. Make sure NATS is configured in natsbridge options", err)
 	}
 
+	if sb.MinSize < 0 {
+		return fmt.Errorf("minSize must not be negative")
+	}
+	if sb.MinSize > 0 && sb.MinSizeAuto {
+		return fmt.Errorf("minSize and minSizeAuto must not be used together")
+	}
+
 	sb.app = natsAppIface.(*natsbridge.NatsBridgeApp)
+	if _, err := sb.app.Server(sb.ServerAlias); err != nil {
+		return err
+	}
+
+	sb.metrics, err = common.GetMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
 
 	return nil
 }
 
 func (sb *StoreBodyToJetStream) ServeHTTP(writer http.ResponseWriter, request *http.Request, handler caddyhttp.Handler) error {
-	b, err := io.ReadAll(request.Body)
+	if sb.MaxSize > 0 && request.ContentLength > sb.MaxSize {
+		return caddyhttp.Error(http.StatusRequestEntityTooLarge,
+			fmt.Errorf("request body of %d bytes exceeds the maximum of %d bytes", request.ContentLength, sb.MaxSize))
+	}
+
+	// the body is streamed to the object store, so the Content-Length cannot be trusted (or is unknown for chunked
+	// uploads); the limit is enforced while reading.
+	var body io.Reader = request.Body
+	if sb.MaxSize > 0 {
+		body = http.MaxBytesReader(writer, request.Body, sb.MaxSize)
+	}
+	threshold, err := sb.threshold(request)
 	if err != nil {
-		return fmt.Errorf("cannot read request body: %w", err)
-	}
-	if len(b) > 0 {
-		// because HTTP headers are changed in camelization ("X-NatsBridge" will become "X-NatsBridge"), we need to store our
-		// extra headers in the Request Context. This way, we can ensure the headers are set as they are configured.
-		// This wouldn't matter much if it was just internal usage; but we want to expose the header name in config (and
-		// it would be very weird if there were additional constraints on the header names)
-		extraNatsMsgHeaders := common.ExtraNatsMsgHeadersFromContext(request.Context())
-		extraNatsMsgHeaders["X-NatsBridge-Body-Bucket"] = sb.Bucket
-		id := nuid.Next()
-		extraNatsMsgHeaders["X-NatsBridge-Body-Id"] = id
-		request = request.WithContext(extraNatsMsgHeaders.StoreInCtx(request.Context()))
-
-		os, err := sb.objectStore()
-		if err != nil {
-			return fmt.Errorf("cannot retrieve object store: %w", err)
+		return err
+	}
+	// read up to the threshold to find out whether the body is stored; Content-Length is not used for this, as it is
+	// unknown for chunked uploads.
+	var head bytes.Buffer
+	if _, err := io.CopyN(&head, body, max(threshold, 1)); err != nil {
+		if err != io.EOF {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return caddyhttp.Error(http.StatusRequestEntityTooLarge,
+					fmt.Errorf("request body exceeds the maximum of %d bytes", maxBytesErr.Limit))
+			}
+			return fmt.Errorf("cannot read request body: %w", err)
 		}
-
-		// NOTE: we could implement this in a streaming fashion to JetStream via
-		// _, err = os.Put(&nats.ObjectMeta{
-		//			Name: fileStreamId,
-		//		}, r.Body)
-		// but we could not make this work.
-		// So that's why we can easily read the full body here anyways to simplify code paths; and then we can
-		// decide based on the actual length; and not based of the ContentLength Header.
-		// In case we want to change it somewhen, we need to take care of Chunked Uploads via r.ContentLength == -1 || r.ContentLength > 950_000_000
-		_, err = os.Put(&nats.ObjectMeta{
-			Name: id,
-		}, bytes.NewReader(b)) // TODO: we cannot directly stream request.Body to os.Put, although it should work type-wise - so we read the full resp into bytes
-		if err != nil {
-			return fmt.Errorf("cannot store binary to Object Store %s: %w", sb.Bucket, err)
+		// requests without body, or with a body below the threshold, are passed on as they are.
+		if head.Len() > 0 {
+			request.Body = io.NopCloser(&head)
+			request.ContentLength = int64(head.Len())
 		}
+		return handler.ServeHTTP(writer, request)
+	}
+
+	// because HTTP headers are changed in camelization ("X-NatsBridge" will become "X-NatsBridge"), we need to store our
+	// extra headers in the Request Context. This way, we can ensure the headers are set as they are configured.
+	// This wouldn't matter much if it was just internal usage; but we want to expose the header name in config (and
+	// it would be very weird if there were additional constraints on the header names)
+	extraNatsMsgHeaders := common.ExtraNatsMsgHeadersFromContext(request.Context())
+	extraNatsMsgHeaders[common.BodyBucketHeader] = sb.Bucket
+	id := nuid.Next()
+	extraNatsMsgHeaders[common.BodyIdHeader] = id
+	request = request.WithContext(extraNatsMsgHeaders.StoreInCtx(request.Context()))
+
+	os, err := sb.objectStore()
+	if err != nil {
+		return fmt.Errorf("cannot retrieve object store: %w", err)
+	}
 
-		// empty the request body for sub-handlers.
-		request.Body = io.NopCloser(bytes.NewReader([]byte{}))
+	// the body is uploaded chunk by chunk. If reading fails (f.e. because the client aborted the upload or the body
+	// is too large) or the request is cancelled, the object store purges the chunks written so far.
+	reader := &bodyReader{r: io.MultiReader(&head, body)}
+	info, err := os.Put(&nats.ObjectMeta{
+		Name: id,
+	}, reader, nats.Context(request.Context()))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return caddyhttp.Error(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body exceeds the maximum of %d bytes", maxBytesErr.Limit))
+		}
+		if reader.err != nil {
+			return fmt.Errorf("cannot read request body: %w", err)
+		}
+		return fmt.Errorf("cannot store binary to Object Store %s: %w", sb.Bucket, err)
 	}
+	sb.metrics.BodiesStored.WithLabelValues(sb.ServerAlias, sb.Bucket).Inc()
+	sb.metrics.PayloadSize.WithLabelValues(sb.ServerAlias, sb.Bucket, "store_body_to_jetstream", common.PayloadDirectionOut).Observe(float64(info.Size))
+
+	// empty the request body for sub-handlers.
+	request.Body = io.NopCloser(bytes.NewReader([]byte{}))
+	request.ContentLength = 0
 
 	return handler.ServeHTTP(writer, request)
 }
 
+// inlineReserve is kept free in the NATS message for the headers added after store_body_to_jetstream, f.e. by
+// nats_publish or nats_request.
+const inlineReserve = 1024
+
+// threshold returns the size from which on the request body is stored. For MinSizeAuto, this is the space left in a
+// NATS message after the request headers.
+func (sb *StoreBodyToJetStream) threshold(request *http.Request) (int64, error) {
+	if !sb.MinSizeAuto {
+		return sb.MinSize, nil
+	}
+	server, err := sb.app.Server(sb.ServerAlias)
+	if err != nil {
+		return 0, err
+	}
+	if server.Conn == nil {
+		return 0, fmt.Errorf("NATS server %s is not connected", sb.ServerAlias)
+	}
+
+	// NATS/1.0\r\n, one line per header value, and the final \r\n.
+	headerSize := len("NATS/1.0\r\n\r\n") + len(request.Method) + len(request.URL.Path) + len(request.URL.RawQuery)
+	for k, values := range request.Header {
+		for _, v := range values {
+			headerSize += len(k) + len(": \r\n") + len(v)
+		}
+	}
+	return max(server.Conn.MaxPayload()-int64(headerSize)-inlineReserve, 1), nil
+}
+
+// bodyReader remembers the error reading the request body, to tell it apart from errors of the object store.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
 // objectStore is lazily initializing the NATS JetStream object store on first access.
 // This is not possible inside Provision(), because we do not know whether the natsbridge.NatsBridgeApp
 // is already set up or not (because provisioning order is not deterministic).
@@ -102,27 +205,15 @@ func (sb *StoreBodyToJetStream) objectStore() (nats.ObjectStore, error) {
 	}
 
 	// set up ObjectStore
-	server := sb.app.Servers[sb.ServerAlias]
-	js, err := server.Conn.JetStream()
+	server, err := sb.app.Server(sb.ServerAlias)
 	if err != nil {
-		return nil, fmt.Errorf("could not load JetStream: %w", err)
-	}
-	os, err := js.ObjectStore(sb.Bucket)
-	if err == nats.ErrStreamNotFound {
-		// Object store does not exist yet, create it.
-		sb.logger.Info("Creating object store", zap.String("Bucket", sb.Bucket), zap.Duration("TTL", sb.TTL))
-		os, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
-			Bucket: sb.Bucket,
-			TTL:    sb.TTL,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("could not create ObjectStore for bucket %s: %w", sb.Bucket, err)
-		}
-	} else if err != nil {
-		return nil, fmt.Errorf("could not load ObjectStore for bucket %s: %w", sb.Bucket, err)
+		return nil, err
 	}
-
-	err = sb.checkIfTTLMatchesConfig(os)
+	js, err := server.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	os, err := common.LoadOrCreateObjectStore(js, sb.Bucket, sb.TTL, sb.logger)
 	if err != nil {
 		return nil, err
 	}
@@ -133,21 +224,6 @@ func (sb *StoreBodyToJetStream) objectStore() (nats.ObjectStore, error) {
 	return os, nil
 }
 
-// checkIfTTLMatchesConfig is called during Provision to check if the objectStore's TTL setting matches the configuration.
-// we do not auto-update it, because it seemed too complex for now.
-func (sb StoreBodyToJetStream) checkIfTTLMatchesConfig(os nats.ObjectStore) error {
-	st, err := os.Status()
-	if err != nil {
-		return fmt.Errorf("could not read ObjectStore Status for bucket %s: %w", sb.Bucket, err)
-	}
-
-	if st.TTL() != sb.TTL {
-		return fmt.Errorf("object store %s TTL Mismatch. Current TTL: %d. Configured TTL: %d", sb.Bucket, st.TTL(), sb.TTL)
-	}
-
-	return nil
-}
-
 var (
 	_ caddyhttp.MiddlewareHandler = (*StoreBodyToJetStream)(nil)
 	_ caddy.Provisioner           = (*StoreBodyToJetStream)(nil)
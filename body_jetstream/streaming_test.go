@@ -0,0 +1,242 @@
+package body_jetstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// abortedReader simulates a client which aborts the upload after sending some data.
+type abortedReader struct {
+	r io.Reader
+}
+
+func (a *abortedReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestStreamingUpload(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "bodies"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// larger than the chunk size of the object store, so it is uploaded in several chunks.
+	large := bytes.Repeat([]byte("x"), 300*1024)
+	small := []byte(`{"hello": "world"}`)
+	// larger than the max payload of the test server (1MB).
+	huge := bytes.Repeat([]byte("x"), 2*1024*1024)
+
+	tests := []struct {
+		name          string
+		maxSize       int64
+		minSize       int64
+		minSizeAuto   bool
+		body          io.Reader
+		contentLength int64
+		expectStored  []byte
+		expectInline  []byte
+		expectStatus  int
+		expectError   bool
+	}{
+		{
+			name:          "chunked upload",
+			body:          bytes.NewReader(large),
+			contentLength: -1,
+			expectStored:  large,
+		},
+		{
+			name:          "upload below max size",
+			maxSize:       int64(len(large)),
+			body:          bytes.NewReader(large),
+			contentLength: int64(len(large)),
+			expectStored:  large,
+		},
+		{
+			name:          "no body",
+			body:          http.NoBody,
+			contentLength: 0,
+		},
+		{
+			name:          "content length above max size",
+			maxSize:       1024,
+			body:          bytes.NewReader(large),
+			contentLength: int64(len(large)),
+			expectStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "chunked upload above max size",
+			maxSize:       200 * 1024,
+			body:          bytes.NewReader(large),
+			contentLength: -1,
+			expectStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "chunked upload below min size",
+			minSize:       1024,
+			body:          bytes.NewReader(small),
+			contentLength: -1,
+			expectInline:  small,
+		},
+		{
+			name:          "chunked upload above min size",
+			minSize:       1024,
+			body:          bytes.NewReader(large),
+			contentLength: -1,
+			expectStored:  large,
+		},
+		{
+			name:          "wrong content length is not trusted",
+			minSize:       1024,
+			body:          bytes.NewReader(large),
+			contentLength: int64(len(small)),
+			expectStored:  large,
+		},
+		{
+			name:          "upload fitting into the max payload",
+			minSizeAuto:   true,
+			body:          bytes.NewReader(large),
+			contentLength: -1,
+			expectInline:  large,
+		},
+		{
+			name:          "upload exceeding the max payload",
+			minSizeAuto:   true,
+			body:          bytes.NewReader(huge),
+			contentLength: -1,
+			expectStored:  huge,
+		},
+		{
+			name:          "inline upload above max size",
+			maxSize:       1024,
+			minSize:       4096,
+			body:          bytes.NewReader(large),
+			contentLength: -1,
+			expectStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "aborted upload",
+			body:          &abortedReader{r: bytes.NewReader(large)},
+			contentLength: -1,
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := &StoreBodyToJetStream{
+				Bucket:      "bodies",
+				ServerAlias: "default",
+				MaxSize:     tt.maxSize,
+				MinSize:     tt.minSize,
+				MinSizeAuto: tt.minSizeAuto,
+				app:         &natsbridge.NatsBridgeApp{Servers: map[string]*natsbridge.NatsServer{"default": {Conn: nc}}},
+				logger:      zap.NewNop(),
+				metrics:     metrics,
+			}
+			sb.os.Store(&store)
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/upload", tt.body)
+			req.ContentLength = tt.contentLength
+			var next *http.Request
+			err := sb.ServeHTTP(httptest.NewRecorder(), req, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				next = r
+				return nil
+			}))
+
+			if tt.expectStatus != 0 || tt.expectError {
+				if err == nil {
+					t.Fatalf("expected an error, but got none")
+				}
+				var handlerErr caddyhttp.HandlerError
+				if tt.expectStatus != 0 && (!errors.As(err, &handlerErr) || handlerErr.StatusCode != tt.expectStatus) {
+					t.Errorf("expected status %d, got: %v", tt.expectStatus, err)
+				}
+				if next != nil {
+					t.Errorf("expected the next handler not to be called")
+				}
+				// partially written objects are cleaned up.
+				info, err := js.StreamInfo("OBJ_bodies")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if info.State.Msgs != 0 {
+					t.Errorf("expected no chunks in the object store, got %d messages", info.State.Msgs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+
+			id := common.ExtraNatsMsgHeadersFromContext(next.Context())[common.BodyIdHeader]
+			if tt.expectStored == nil {
+				if id != "" {
+					t.Errorf("expected no body to be stored, got %s", id)
+				}
+				if b, _ := io.ReadAll(next.Body); !bytes.Equal(b, tt.expectInline) {
+					t.Errorf("expected the body to be passed on inline (%d bytes), got %d bytes", len(tt.expectInline), len(b))
+				}
+				if tt.expectInline != nil && next.ContentLength != int64(len(tt.expectInline)) {
+					t.Errorf("expected content length %d, got %d", len(tt.expectInline), next.ContentLength)
+				}
+				return
+			}
+			stored, err := store.GetBytes(id)
+			if err != nil {
+				t.Fatalf("expected the body to be stored, but got: %v", err)
+			}
+			if !bytes.Equal(stored, tt.expectStored) {
+				t.Errorf("expected the stored body to match the request body (%d bytes), got %d bytes", len(tt.expectStored), len(stored))
+			}
+			if b, _ := io.ReadAll(next.Body); len(b) != 0 || next.ContentLength != 0 {
+				t.Errorf("expected an empty body for the next handler")
+			}
+			if err := store.Delete(id); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := js.PurgeStream("OBJ_bodies"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// ParseHeader parses a "header <name> <value>" subdirective into headers, allocating the map if necessary.
+func ParseHeader(d *caddyfile.Dispenser, headers *map[string]string) error {
+	var name, value string
+	if !d.AllArgs(&name, &value) {
+		return d.ArgErr()
+	}
+	if *headers == nil {
+		*headers = make(map[string]string)
+	}
+	(*headers)[name] = value
+	return nil
+}
+
+// ParseInt parses the single numeric argument of the current subdirective into target.
+func ParseInt(d *caddyfile.Dispenser, target *int) error {
+	name := d.Val()
+	var val string
+	if !d.AllArgs(&val) {
+		return d.ArgErr()
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return d.Errf("%s must be a number: %v", name, err)
+	}
+	*target = n
+	return nil
+}
+
+// ParseSubjectPrefix parses a "subject_prefix <prefix>" subdirective of a handler, which overrides the subject prefix
+// of the server. Use "" to disable the prefix.
+func ParseSubjectPrefix(d *caddyfile.Dispenser, prefix **string) error {
+	var p string
+	if !d.AllArgs(&p) {
+		return d.ArgErr()
+	}
+	*prefix = &p
+	return nil
+}
+
+// ParseFetchBody parses a fetch_body subdirective of a handler. Syntax:
+//
+//	fetch_body [bucket...] {
+//	    [delete]
+//	}
+func ParseFetchBody(d *caddyfile.Dispenser) (*FetchBody, error) {
+	fb := FetchBody{Buckets: d.RemainingArgs()}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "delete":
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			fb.Delete = true
+		default:
+			return nil, d.Errf("unrecognized fetch_body subdirective: %s", d.Val())
+		}
+	}
+
+	return &fb, nil
+}
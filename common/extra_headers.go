@@ -4,6 +4,12 @@ import (
 	"context"
 )
 
+// Headers referencing a body which was offloaded to a JetStream object store by store_body_to_jetstream.
+const (
+	BodyBucketHeader = "X-NatsBridge-Body-Bucket"
+	BodyIdHeader     = "X-NatsBridge-Body-Id"
+)
+
 type ExtraNatsMsgHeaders map[string]string
 
 const extraNatsMsgHeadersKey = "ExtraNatsMsgHeaders"
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/nats-io/nats.go"
+)
+
+// FetchBody fetches bodies which were offloaded to a JetStream object store (and are referenced by the
+// X-NatsBridge-Body-Bucket and X-NatsBridge-Body-Id headers), f.e. by store_body_to_jetstream or offload_response.
+type FetchBody struct {
+	// Buckets restricts the object stores bodies are fetched from; if empty, all buckets are allowed.
+	Buckets []string `json:"buckets,omitempty"`
+	// Delete removes the object from the object store after the body was used successfully.
+	Delete bool `json:"delete,omitempty"`
+}
+
+// OffloadedBody is a body fetched from a JetStream object store.
+type OffloadedBody struct {
+	io.ReadCloser
+	Info  *nats.ObjectInfo
+	Store nats.ObjectStore
+}
+
+// FetchError is returned if an offloaded body cannot be fetched; Status is the HTTP status describing the error.
+type FetchError struct {
+	Status int
+	Err    error
+}
+
+func (e *FetchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Fetch returns the offloaded body referenced by the headers of a message; nil if the message does not reference
+// one. jetStream returns the JetStream context of the server the message was received from.
+func (f *FetchBody) Fetch(header nats.Header, jetStream func() (nats.JetStreamContext, error)) (*OffloadedBody, error) {
+	bucket, id := header.Get(BodyBucketHeader), header.Get(BodyIdHeader)
+	if bucket == "" || id == "" {
+		return nil, nil
+	}
+	if len(f.Buckets) > 0 && !slices.Contains(f.Buckets, bucket) {
+		return nil, &FetchError{http.StatusForbidden, fmt.Errorf("fetching bodies from bucket %s is not allowed", bucket)}
+	}
+
+	if jetStream == nil {
+		return nil, &FetchError{http.StatusBadGateway, fmt.Errorf("JetStream is not available")}
+	}
+	js, err := jetStream()
+	if err != nil {
+		return nil, &FetchError{http.StatusBadGateway, err}
+	}
+	store, err := js.ObjectStore(bucket)
+	if err != nil {
+		return nil, fetchError(fmt.Errorf("could not load object store %s: %w", bucket, err))
+	}
+	obj, err := store.Get(id)
+	if err != nil {
+		return nil, fetchError(fmt.Errorf("could not fetch body %s from object store %s: %w", id, bucket, err))
+	}
+	info, err := obj.Info()
+	if err != nil {
+		_ = obj.Close()
+		return nil, fetchError(fmt.Errorf("could not fetch body %s from object store %s: %w", id, bucket, err))
+	}
+	return &OffloadedBody{ReadCloser: obj, Info: info, Store: store}, nil
+}
+
+// fetchError converts errors of the object store: a missing body will never appear, so it is reported as 410 Gone;
+// all other errors as 502 Bad Gateway.
+func fetchError(err error) *FetchError {
+	if errors.Is(err, nats.ErrObjectNotFound) || errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		return &FetchError{http.StatusGone, err}
+	}
+	return &FetchError{http.StatusBadGateway, err}
+}
+
+// Remove deletes the body from its object store.
+func (b *OffloadedBody) Remove() error {
+	return b.Store.Delete(b.Info.Name)
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// InFlight counts in-flight operations, so a graceful shutdown can wait for them to complete.
+// The zero value is ready to use.
+type InFlight struct {
+	mu    sync.Mutex
+	count int
+	// idle is closed as soon as count drops to zero.
+	idle chan struct{}
+}
+
+// Begin marks the start of an operation; End must be called once it is completed.
+func (f *InFlight) Begin() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.count == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.count++
+}
+
+func (f *InFlight) End() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count--
+	if f.count == 0 {
+		close(f.idle)
+	}
+}
+
+// Wait blocks until no operation is in flight anymore, or ctx is done. It returns the number of operations which
+// are still in flight, i.e. which are abandoned.
+func (f *InFlight) Wait(ctx context.Context) int {
+	f.mu.Lock()
+	if f.count == 0 {
+		f.mu.Unlock()
+		return 0
+	}
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return 0
+	case <-ctx.Done():
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.count
+	}
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInFlight(t *testing.T) {
+	var f InFlight
+	if abandoned := f.Wait(context.Background()); abandoned != 0 {
+		t.Errorf("expected nothing in flight, got %d", abandoned)
+	}
+
+	f.Begin()
+	f.Begin()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.End()
+		f.End()
+	}()
+	if abandoned := f.Wait(context.Background()); abandoned != 0 {
+		t.Errorf("expected all operations to complete, got %d abandoned", abandoned)
+	}
+
+	f.Begin()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if abandoned := f.Wait(ctx); abandoned != 1 {
+		t.Errorf("expected 1 abandoned operation, got %d", abandoned)
+	}
+	f.End()
+}
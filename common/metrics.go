@@ -0,0 +1,164 @@
+package common
+
+import (
+	"errors"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics contains all Prometheus collectors of the NATS bridge. They are registered into Caddy's metrics registry,
+// so they are exposed on the existing metrics endpoint.
+//
+// All metrics are labeled with the server alias; most also with a subject label. The subject label is the
+// configured subject template (or an explicitly configured metrics subject), and NOT the expanded subject, to keep
+// the cardinality low.
+type Metrics struct {
+	MessagesPublished *prometheus.CounterVec
+	Requests          *prometheus.CounterVec
+	RequestDuration   *prometheus.HistogramVec
+	PayloadSize       *prometheus.HistogramVec
+	SubscribeMessages *prometheus.CounterVec
+	SubscribeInFlight *prometheus.GaugeVec
+	SubscribeDuration *prometheus.HistogramVec
+	SubscribeRejected *prometheus.CounterVec
+	DeadLettered      *prometheus.CounterVec
+	BodiesStored      *prometheus.CounterVec
+	Reconnects        *prometheus.CounterVec
+}
+
+const (
+	metricsNamespace = "caddy"
+	metricsSubsystem = "nats"
+
+	RequestOutcomeOk           = "ok"
+	RequestOutcomeTimeout      = "timeout"
+	RequestOutcomeNoResponders = "no_responders"
+	RequestOutcomeError        = "error"
+
+	PayloadDirectionIn  = "in"
+	PayloadDirectionOut = "out"
+)
+
+// GetMetrics returns the metrics of the NATS bridge for the given Caddy context. It can be called by every module
+// during provisioning; the collectors are registered only once per metrics registry (i.e. per config load).
+func GetMetrics(ctx caddy.Context) (*Metrics, error) {
+	registry := ctx.GetMetricsRegistry()
+	if registry == nil {
+		// only happens for contexts not created by Caddy (f.e. in tests); the metrics are simply not exposed then.
+		registry = prometheus.NewRegistry()
+	}
+	durationBuckets := prometheus.DefBuckets
+	sizeBuckets := prometheus.ExponentialBuckets(256, 4, 8)
+
+	m := &Metrics{}
+	var err error
+	if m.MessagesPublished, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "messages_published_total",
+		Help:      "Number of NATS messages published (fire-and-forget).",
+	}, []string{"server", "subject", "handler"})); err != nil {
+		return nil, err
+	}
+	if m.Requests, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "requests_total",
+		Help:      "Number of NATS requests done by nats_request, by outcome (ok, timeout, no_responders, error).",
+	}, []string{"server", "subject", "outcome"})); err != nil {
+		return nil, err
+	}
+	if m.RequestDuration, err = register(registry, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "request_duration_seconds",
+		Help:      "Histogram of NATS request round-trip durations.",
+		Buckets:   durationBuckets,
+	}, []string{"server", "subject"})); err != nil {
+		return nil, err
+	}
+	if m.PayloadSize, err = register(registry, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "payload_size_bytes",
+		Help:      "Histogram of NATS message payload sizes, sent (out) to or received (in) from NATS.",
+		Buckets:   sizeBuckets,
+	}, []string{"server", "subject", "handler", "direction"})); err != nil {
+		return nil, err
+	}
+	if m.SubscribeMessages, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "subscribe_messages_total",
+		Help:      "Number of NATS messages received by subscribe handlers.",
+	}, []string{"server", "subject"})); err != nil {
+		return nil, err
+	}
+	if m.SubscribeInFlight, err = register(registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "subscribe_in_flight",
+		Help:      "Number of NATS messages currently dispatched to HTTP by subscribe handlers.",
+	}, []string{"server", "subject"})); err != nil {
+		return nil, err
+	}
+	if m.SubscribeDuration, err = register(registry, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "subscribe_duration_seconds",
+		Help:      "Histogram of the HTTP dispatch durations of subscribe handlers.",
+		Buckets:   durationBuckets,
+	}, []string{"server", "subject"})); err != nil {
+		return nil, err
+	}
+	if m.SubscribeRejected, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "subscribe_rejected_total",
+		Help:      "Number of NATS messages rejected by subscribe handlers because all workers were busy, by saturation policy (drop, reply_503).",
+	}, []string{"server", "subject", "policy"})); err != nil {
+		return nil, err
+	}
+	if m.DeadLettered, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "subscribe_dead_lettered_total",
+		Help:      "Number of NATS messages published to the dead-letter subject of subscribe handlers, by outcome (ok, error).",
+	}, []string{"server", "subject", "outcome"})); err != nil {
+		return nil, err
+	}
+	if m.BodiesStored, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "bodies_stored_total",
+		Help:      "Number of HTTP request (or subscribe response) bodies stored to a JetStream object store.",
+	}, []string{"server", "bucket"})); err != nil {
+		return nil, err
+	}
+	if m.Reconnects, err = register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "reconnects_total",
+		Help:      "Number of reconnects to the NATS server.",
+	}, []string{"server"})); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// register registers the collector, or returns the already registered one with the same descriptor.
+func register[T prometheus.Collector](registry *prometheus.Registry, collector T) (T, error) {
+	err := registry.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+	return collector, err
+}
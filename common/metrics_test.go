@@ -0,0 +1,38 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestGetMetrics(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	first, err := GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// all modules of a config share the collectors registered by the first one.
+	second, err := GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.MessagesPublished != second.MessagesPublished || first.RequestDuration != second.RequestDuration || first.Reconnects != second.Reconnects {
+		t.Errorf("expected the collectors to be shared")
+	}
+
+	first.MessagesPublished.WithLabelValues("default", "greet", "nats_publish").Inc()
+	families, err := ctx.GetMetricsRegistry().Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "caddy_nats_messages_published_total" {
+			return
+		}
+	}
+	t.Errorf("expected caddy_nats_messages_published_total to be exposed by Caddy's metrics registry")
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// LoadOrCreateObjectStore returns the JetStream object store of the bucket, and creates it with the given TTL if it
+// does not exist yet. The TTL of an existing bucket must match the configured one; it is not updated automatically,
+// because it seemed too complex for now.
+func LoadOrCreateObjectStore(js nats.JetStreamContext, bucket string, ttl time.Duration, logger *zap.Logger) (nats.ObjectStore, error) {
+	os, err := js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		// Object store does not exist yet, create it.
+		logger.Info("Creating object store", zap.String("Bucket", bucket), zap.Duration("TTL", ttl))
+		os, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket: bucket,
+			TTL:    ttl,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create ObjectStore for bucket %s: %w", bucket, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("could not load ObjectStore for bucket %s: %w", bucket, err)
+	}
+
+	st, err := os.Status()
+	if err != nil {
+		return nil, fmt.Errorf("could not read ObjectStore Status for bucket %s: %w", bucket, err)
+	}
+	if st.TTL() != ttl {
+		return nil, fmt.Errorf("object store %s TTL Mismatch. Current TTL: %d. Configured TTL: %d", bucket, st.TTL(), ttl)
+	}
+
+	return os, nil
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestLoadOrCreateObjectStore(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := LoadOrCreateObjectStore(js, "bodies", 5*time.Minute, zap.NewNop()); err != nil {
+		t.Fatalf("expected the bucket to be created, but got: %v", err)
+	}
+	if _, err := js.ObjectStore("bodies"); err != nil {
+		t.Errorf("expected the bucket to exist, but got: %v", err)
+	}
+	if _, err := LoadOrCreateObjectStore(js, "bodies", 5*time.Minute, zap.NewNop()); err != nil {
+		t.Errorf("expected the existing bucket to be loaded, but got: %v", err)
+	}
+	if _, err := LoadOrCreateObjectStore(js, "bodies", time.Minute, zap.NewNop()); err == nil {
+		t.Errorf("expected an error, but got none")
+	}
+}
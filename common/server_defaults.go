@@ -0,0 +1,34 @@
+package common
+
+import "github.com/nats-io/nats.go"
+
+// ServerDefaults are configured per NATS server alias, and are inherited by all handlers and log outputs bound to it.
+type ServerDefaults struct {
+	// SubjectPrefix is prepended to all subjects.
+	SubjectPrefix string
+	// Headers are added to all NATS messages (or HTTP requests for subscribe).
+	Headers map[string]string
+	// JetStream returns the shared JetStream context of the server, which honors its jetstream options.
+	JetStream func() (nats.JetStreamContext, error)
+}
+
+// Subject prepends the subject prefix to the given subject; the prefix ends with a dot, see ValidateSubjectPrefix.
+// If prefixOverride is set, it is used instead of the server's subject prefix; an empty override disables the prefix.
+func (d ServerDefaults) Subject(subject string, prefixOverride *string) string {
+	if prefixOverride != nil {
+		return *prefixOverride + subject
+	}
+	return d.SubjectPrefix + subject
+}
+
+// MergedHeaders returns the server's headers, overridden by the given handler headers.
+func (d ServerDefaults) MergedHeaders(overrides map[string]string) map[string]string {
+	headers := make(map[string]string, len(d.Headers)+len(overrides))
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	for k, v := range overrides {
+		headers[k] = v
+	}
+	return headers
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerDefaults(t *testing.T) {
+	defaults := ServerDefaults{
+		SubjectPrefix: "tenant-a.",
+		Headers:       map[string]string{"X-Tenant": "a", "X-Source": "caddy"},
+	}
+	override := "tenant-b."
+	noPrefix := ""
+
+	if subj := defaults.Subject("orders.new", nil); subj != "tenant-a.orders.new" {
+		t.Errorf("expected inherited prefix, got %s", subj)
+	}
+	if subj := defaults.Subject("orders.new", &override); subj != "tenant-b.orders.new" {
+		t.Errorf("expected overridden prefix, got %s", subj)
+	}
+	if subj := defaults.Subject("orders.new", &noPrefix); subj != "orders.new" {
+		t.Errorf("expected disabled prefix, got %s", subj)
+	}
+
+	headers := defaults.MergedHeaders(map[string]string{"X-Tenant": "b"})
+	expected := map[string]string{"X-Tenant": "b", "X-Source": "caddy"}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("expected headers %v, got %v", expected, headers)
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// subjectWhitespace can never be part of a subject.
+const subjectWhitespace = " \t\r\n"
+
+// invalidSubjectChars can never be part of a subject a message is published to.
+const invalidSubjectChars = subjectWhitespace + ">*"
+
+// ValidateSubjectTemplate checks the static parts of a subject to publish to, which may contain Caddy placeholders:
+// they must not contain wildcards, whitespace or empty tokens. Placeholders are only resolved per message, so they
+// are not checked.
+func ValidateSubjectTemplate(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("subject must not be empty")
+	}
+	return validateSubject(subject, false)
+}
+
+// ValidateSubscribeSubject checks a subject to subscribe to. In contrast to ValidateSubjectTemplate, it may contain the
+// wildcards "*" and ">" as full tokens, and ">" only as the last one. Subscribe subjects are not expanded, so they
+// contain no placeholders.
+func ValidateSubscribeSubject(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("subject must not be empty")
+	}
+	if i := strings.IndexAny(subject, subjectWhitespace); i >= 0 {
+		return fmt.Errorf("invalid subject %q: character %q is not allowed", subject, subject[i])
+	}
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return fmt.Errorf("invalid subject %q: empty token", subject)
+		case token == ">" && i < len(tokens)-1:
+			return fmt.Errorf("invalid subject %q: wildcard '>' must be the last token", subject)
+		case token != "*" && token != ">" && strings.ContainsAny(token, "*>"):
+			return fmt.Errorf("invalid subject %q: wildcards must be full tokens", subject)
+		}
+	}
+	return nil
+}
+
+// ValidateSubjectPrefix checks a subject prefix like ValidateSubjectTemplate. The prefix is prepended to the subjects
+// as it is, so it must end with a dot; otherwise "tenant" and "foo" would result in "tenantfoo".
+func ValidateSubjectPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if !strings.HasSuffix(prefix, ".") {
+		return fmt.Errorf("invalid subject prefix %q: must end with a dot", prefix)
+	}
+	return validateSubject(prefix, true)
+}
+
+func validateSubject(subject string, isPrefix bool) error {
+	masked := maskPlaceholders(subject)
+	if i := strings.IndexAny(masked, invalidSubjectChars); i >= 0 {
+		return fmt.Errorf("invalid subject %q: character %q is not allowed", subject, masked[i])
+	}
+	if isPrefix {
+		masked = strings.TrimSuffix(masked, ".")
+	}
+	for _, token := range strings.Split(masked, ".") {
+		if token == "" {
+			return fmt.Errorf("invalid subject %q: empty token", subject)
+		}
+	}
+	return nil
+}
+
+// maskPlaceholders replaces all placeholders of a Caddy replacer template with a valid token character, so only the
+// static parts remain to be checked.
+func maskPlaceholders(template string) string {
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '\\' && i+1 < len(template) && (template[i+1] == '{' || template[i+1] == '}'):
+			// escaped brace
+			i++
+			sb.WriteByte(template[i])
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				// unclosed braces are kept as they are by the replacer.
+				sb.WriteString(template[i:])
+				return sb.String()
+			}
+			sb.WriteByte('_')
+			i += end
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
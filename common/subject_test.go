@@ -0,0 +1,83 @@
+package common
+
+import "testing"
+
+func TestValidateSubjectTemplate(t *testing.T) {
+	tests := []struct {
+		subject     string
+		expectError bool
+	}{
+		{subject: "events.hello"},
+		{subject: "events.{http.request.uri.path.asNatsSubject}"},
+		{subject: "{http.request.header.X-Tenant}.events"},
+		{subject: "events.{http.request.header.X Tenant}"},
+		{subject: "events.\\{literal\\}"},
+		{subject: "", expectError: true},
+		{subject: "events.>", expectError: true},
+		{subject: "events.*.hello", expectError: true},
+		{subject: "events hello", expectError: true},
+		{subject: "$events.hello"},
+		{subject: "$KV.bucket.key"},
+		{subject: "events..hello", expectError: true},
+		{subject: ".events", expectError: true},
+		{subject: "events.", expectError: true},
+		{subject: "events.{path}.", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			err := ValidateSubjectTemplate(tt.subject)
+			if tt.expectError && err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSubscribeSubject(t *testing.T) {
+	tests := []struct {
+		subject     string
+		expectError bool
+	}{
+		{subject: "events.hello"},
+		{subject: "events.*.hello"},
+		{subject: "events.>"},
+		{subject: ">"},
+		{subject: "$JS.API.>"},
+		{subject: "", expectError: true},
+		{subject: "events.>.hello", expectError: true},
+		{subject: "events.hel*", expectError: true},
+		{subject: "events.>>", expectError: true},
+		{subject: "events hello", expectError: true},
+		{subject: "events..hello", expectError: true},
+		{subject: "events.", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			err := ValidateSubscribeSubject(tt.subject)
+			if tt.expectError && err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSubjectPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "tenant-a.", "tenant.{env}."} {
+		if err := ValidateSubjectPrefix(prefix); err != nil {
+			t.Errorf("expected no error for %q, but got: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"tenant", "tenant.{env}", ".tenant.", "tenant..", "tenant.*."} {
+		if err := ValidateSubjectPrefix(prefix); err == nil {
+			t.Errorf("expected an error for %q, but got none", prefix)
+		}
+	}
+}
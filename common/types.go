@@ -1,8 +1,28 @@
 package common
 
-import "github.com/nats-io/nats.go"
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
 
 type NatsHandler interface {
-	Subscribe(conn *nats.Conn) error
-	Unsubscribe(conn *nats.Conn) error
+	// Subscribe is called once the connection to the NATS server with the given alias is established.
+	Subscribe(serverAlias string, defaults ServerDefaults, conn *nats.Conn) error
+	// Unsubscribe is called when the app is stopped. It should wait for in-flight messages to be processed until
+	// ctx is done.
+	Unsubscribe(ctx context.Context, conn *nats.Conn) error
+	// SubscriptionInfo returns the current state of the handler's subscription, f.e. for the admin API.
+	SubscriptionInfo() SubscriptionInfo
+}
+
+// SubscriptionInfo describes the state of a NATS subscription of a NatsHandler.
+type SubscriptionInfo struct {
+	Subject      string `json:"subject"`
+	QueueGroup   string `json:"queue_group,omitempty"`
+	Active       bool   `json:"active"`
+	PendingMsgs  int    `json:"pending_msgs"`
+	PendingBytes int    `json:"pending_bytes"`
+	Dropped      int    `json:"dropped"`
+	Delivered    int64  `json:"delivered"`
 }
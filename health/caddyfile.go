@@ -0,0 +1,44 @@
+package health
+
+import (
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// ParseHealthHandler parses the nats_health directive. Syntax:
+//
+//	nats_health [serverAlias...] {
+//	    [rtt_timeout 1s]
+//	}
+func ParseHealthHandler(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var hh = Health{}
+	err := hh.UnmarshalCaddyfile(h.Dispenser)
+	return hh, err
+}
+func (hh *Health) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		hh.ServerAliases = append(hh.ServerAliases, d.RemainingArgs()...)
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "rtt_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				t, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Err("rtt_timeout is not a valid duration")
+				}
+
+				hh.RttTimeout = t
+			default:
+				return d.Errf("unrecognized subdirective: %s", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// Health is a terminal HTTP handler reporting the connectivity of the NATS servers as JSON. It responds with
+// 200 if all checked servers are connected, and with 503 otherwise - so it can be used as readiness endpoint.
+type Health struct {
+	// ServerAliases to check; if empty, all configured servers are checked.
+	ServerAliases []string `json:"serverAliases,omitempty"`
+	// RttTimeout enables a round-trip check to the NATS server (via Flush) with the given timeout.
+	RttTimeout time.Duration `json:"rttTimeout,omitempty"`
+
+	logger *zap.Logger
+	app    *natsbridge.NatsBridgeApp
+}
+
+type healthResponse struct {
+	Healthy bool                    `json:"healthy"`
+	Servers map[string]serverHealth `json:"servers"`
+}
+
+type serverHealth struct {
+	Healthy       bool       `json:"healthy"`
+	Status        string     `json:"status"`
+	ConnectedUrl  string     `json:"connected_url,omitempty"`
+	LastReconnect *time.Time `json:"last_reconnect,omitempty"`
+	Rtt           string     `json:"rtt,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+func (Health) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.nats_health",
+		New: func() caddy.Module { return new(Health) },
+	}
+}
+
+func (h *Health) Provision(ctx caddy.Context) error {
+	h.logger = ctx.Logger(h)
+
+	natsAppIface, err := ctx.App("nats")
+	if err != nil {
+		return fmt.Errorf("getting NATS app: %v. Make sure NATS is configured in nats options", err)
+	}
+	h.app = natsAppIface.(*natsbridge.NatsBridgeApp)
+
+	if len(h.ServerAliases) == 0 {
+		for alias := range h.app.Servers {
+			h.ServerAliases = append(h.ServerAliases, alias)
+		}
+		sort.Strings(h.ServerAliases)
+	}
+	for _, alias := range h.ServerAliases {
+		if _, err := h.app.Server(alias); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h Health) ServeHTTP(w http.ResponseWriter, r *http.Request, _ caddyhttp.Handler) error {
+	resp := healthResponse{
+		Healthy: true,
+		Servers: make(map[string]serverHealth, len(h.ServerAliases)),
+	}
+	for _, alias := range h.ServerAliases {
+		sh := h.check(h.app.Servers[alias])
+		if !sh.Healthy {
+			resp.Healthy = false
+			h.logger.Debug("NATS server unhealthy", zap.String("serverAlias", alias), zap.String("status", sh.Status), zap.String("error", sh.Error))
+		}
+		resp.Servers[alias] = sh
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		return fmt.Errorf("could not write health response: %w", err)
+	}
+	return nil
+}
+
+func (h Health) check(server *natsbridge.NatsServer) serverHealth {
+	if server.Conn == nil {
+		return serverHealth{Status: "NOT_STARTED"}
+	}
+
+	sh := serverHealth{
+		Healthy:      server.Conn.IsConnected(),
+		Status:       server.Conn.Status().String(),
+		ConnectedUrl: server.Conn.ConnectedUrlRedacted(),
+	}
+	if lastReconnect := server.LastReconnect(); !lastReconnect.IsZero() {
+		sh.LastReconnect = &lastReconnect
+	}
+
+	if sh.Healthy && h.RttTimeout > 0 {
+		start := time.Now()
+		if err := server.Conn.FlushTimeout(h.RttTimeout); err != nil {
+			sh.Healthy = false
+			sh.Error = err.Error()
+		} else {
+			sh.Rtt = time.Since(start).String()
+		}
+	}
+
+	return sh
+}
+
+var (
+	_ caddyhttp.MiddlewareHandler = (*Health)(nil)
+	_ caddy.Provisioner           = (*Health)(nil)
+	_ caddyfile.Unmarshaler       = (*Health)(nil)
+)
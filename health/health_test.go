@@ -0,0 +1,40 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"go.uber.org/zap"
+)
+
+func TestHealthReportsServersWhichAreNotStarted(t *testing.T) {
+	h := Health{
+		ServerAliases: []string{"default"},
+		logger:        zap.NewNop(),
+		app: &natsbridge.NatsBridgeApp{
+			Servers: map[string]*natsbridge.NatsServer{
+				"default": {},
+			},
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	err := h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rec.Code)
+	}
+	var resp healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp.Healthy || resp.Servers["default"].Healthy || resp.Servers["default"].Status != "NOT_STARTED" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
@@ -1,6 +1,7 @@
 package logoutput
 
 import (
+	"github.com/CoverWhale/caddy-nats-bridge/common"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 )
 
@@ -29,6 +30,14 @@ func (p *LogOutput) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			}
 
 			p.Timeout = t*/
+			case "subject_prefix":
+				if err := common.ParseSubjectPrefix(d, &p.SubjectPrefix); err != nil {
+					return err
+				}
+			case "header":
+				if err := common.ParseHeader(d, &p.Headers); err != nil {
+					return err
+				}
 			default:
 				return d.Errf("unrecognized subdirective: %s", d.Val())
 			}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/CoverWhale/caddy-nats-bridge/common"
 	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
@@ -14,9 +15,14 @@ import (
 type LogOutput struct {
 	Subject     string `json:"subject,omitempty"`
 	ServerAlias string `json:"serverAlias,omitempty"`
+	// SubjectPrefix overrides the subject prefix of the server; an empty string disables it.
+	SubjectPrefix *string `json:"subjectPrefix,omitempty"`
+	// Headers are added to every log message, overriding the headers of the server with the same name.
+	Headers map[string]string `json:"headers,omitempty"`
 
 	logger   *zap.Logger
 	caddyCtx caddy.Context
+	metrics  *common.Metrics
 }
 
 func (LogOutput) CaddyModule() caddy.ModuleInfo {
@@ -35,6 +41,26 @@ func (p *LogOutput) Provision(ctx caddy.Context) error {
 	p.logger = ctx.Logger(p)
 	p.caddyCtx = ctx
 
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+	p.metrics = metrics
+
+	// the nats app is not available yet, so it validates the server alias itself; the prefix of the server is
+	// validated by the app as well.
+	natsbridge.RequireServerAlias(ctx, p.ServerAlias, "log output "+p.String())
+	subject := p.Subject
+	if p.SubjectPrefix != nil {
+		if err := common.ValidateSubjectPrefix(*p.SubjectPrefix); err != nil {
+			return err
+		}
+		subject = *p.SubjectPrefix + subject
+	}
+	if err := common.ValidateSubjectTemplate(subject); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -54,7 +80,7 @@ func (p LogOutput) OpenWriter() (io.WriteCloser, error) {
 
 type LogOutputWriter struct {
 	logOutput LogOutput
-	natsConn  *nats.Conn
+	server    *natsbridge.NatsServer
 }
 
 func (lw LogOutputWriter) Write(msg []byte) (n int, err error) {
@@ -65,23 +91,31 @@ func (lw LogOutputWriter) Write(msg []byte) (n int, err error) {
 	// calling caddyCtx.App("nats") will crash in case newCfg.apps is not properly initialized as Map yet.
 	//
 	// => WORKAROUND: we fetch the natsConnection here, when sending the 1st log message.
-	if lw.natsConn == nil {
+	if lw.server == nil {
 		natsAppIface, err := lw.logOutput.caddyCtx.App("nats")
 		if err != nil {
 			return 0, fmt.Errorf("getting NATS app: %w. Make sure NATS is configured in nats options", err)
 		}
 		app := natsAppIface.(*natsbridge.NatsBridgeApp)
-		server, ok := app.Servers[lw.logOutput.ServerAlias]
-		if !ok {
-			return 0, fmt.Errorf("NATS server alias %s not found", lw.logOutput.ServerAlias)
+		server, err := app.Server(lw.logOutput.ServerAlias)
+		if err != nil {
+			return 0, err
 		}
-		lw.natsConn = server.Conn
+		lw.server = server
 	}
 
-	err = lw.natsConn.Publish(lw.logOutput.Subject, msg)
+	defaults := lw.server.Defaults()
+	natsMsg := nats.NewMsg(defaults.Subject(lw.logOutput.Subject, lw.logOutput.SubjectPrefix))
+	natsMsg.Data = msg
+	for k, v := range defaults.MergedHeaders(lw.logOutput.Headers) {
+		natsMsg.Header.Set(k, v)
+	}
+	err = lw.server.Conn.PublishMsg(natsMsg)
 	if err != nil {
 		return 0, fmt.Errorf("error writing log message: %w", err)
 	}
+	lw.logOutput.metrics.MessagesPublished.WithLabelValues(lw.logOutput.ServerAlias, lw.logOutput.Subject, "log_output").Inc()
+	lw.logOutput.metrics.PayloadSize.WithLabelValues(lw.logOutput.ServerAlias, lw.logOutput.Subject, "log_output", common.PayloadDirectionOut).Observe(float64(len(msg)))
 	return len(msg), nil
 }
 
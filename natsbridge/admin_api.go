@@ -0,0 +1,168 @@
+package natsbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+const adminNatsEndpointBase = "/nats/"
+
+// AdminAPI is a module that serves endpoints to inspect the NATS connections and subscriptions at runtime:
+//
+//	GET /nats/servers        connection state and statistics per server alias
+//	GET /nats/subscriptions  state of all subscribe handlers per server alias
+type AdminAPI struct {
+	logger *zap.Logger
+	app    *NatsBridgeApp
+}
+
+type serverInfo struct {
+	Status        string   `json:"status"`
+	ConnectedUrl  string   `json:"connected_url,omitempty"`
+	ServerId      string   `json:"server_id,omitempty"`
+	ServerName    string   `json:"server_name,omitempty"`
+	ServerVersion string   `json:"server_version,omitempty"`
+	ClusterName   string   `json:"cluster_name,omitempty"`
+	MaxPayload    int64    `json:"max_payload,omitempty"`
+	Rtt           string   `json:"rtt,omitempty"`
+	RttError      string   `json:"rtt_error,omitempty"`
+	Servers       []string `json:"servers,omitempty"`
+	InMsgs        uint64   `json:"in_msgs"`
+	OutMsgs       uint64   `json:"out_msgs"`
+	InBytes       uint64   `json:"in_bytes"`
+	OutBytes      uint64   `json:"out_bytes"`
+	Reconnects    uint64   `json:"reconnects"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.nats",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Provision sets up the admin API module.
+func (a *AdminAPI) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger(a)
+
+	// the admin API is loaded for every config; the error is ignored because it is not fatal if NATS is not configured.
+	natsAppIface, err := ctx.AppIfConfigured("nats")
+	if err == nil {
+		a.app = natsAppIface.(*NatsBridgeApp)
+	}
+
+	return nil
+}
+
+// Routes returns the admin routes for the nats app.
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: adminNatsEndpointBase,
+			Handler: caddy.AdminHandlerFunc(a.handleAPIEndpoints),
+		},
+	}
+}
+
+func (a *AdminAPI) handleAPIEndpoints(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	if a.app == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("nats app is not configured"),
+		}
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, adminNatsEndpointBase) {
+	case "servers":
+		return writeJSON(w, a.servers())
+	case "subscriptions":
+		return writeJSON(w, a.subscriptions())
+	}
+
+	return caddy.APIError{
+		HTTPStatus: http.StatusNotFound,
+		Err:        fmt.Errorf("resource not found: %v", r.URL.Path),
+	}
+}
+
+func (a *AdminAPI) servers() map[string]serverInfo {
+	result := make(map[string]serverInfo, len(a.app.Servers))
+	for alias, server := range a.app.Servers {
+		if server.Conn == nil {
+			result[alias] = serverInfo{Status: "NOT_STARTED"}
+			continue
+		}
+
+		conn := server.Conn
+		stats := conn.Stats()
+		info := serverInfo{
+			Status:        conn.Status().String(),
+			ConnectedUrl:  conn.ConnectedUrlRedacted(),
+			ServerId:      conn.ConnectedServerId(),
+			ServerName:    conn.ConnectedServerName(),
+			ServerVersion: conn.ConnectedServerVersion(),
+			ClusterName:   conn.ConnectedClusterName(),
+			MaxPayload:    conn.MaxPayload(),
+			Servers:       conn.Servers(),
+			InMsgs:        stats.InMsgs,
+			OutMsgs:       stats.OutMsgs,
+			InBytes:       stats.InBytes,
+			OutBytes:      stats.OutBytes,
+			Reconnects:    stats.Reconnects,
+		}
+		if conn.IsConnected() {
+			rtt, err := conn.RTT()
+			if err != nil {
+				info.RttError = err.Error()
+			} else {
+				info.Rtt = rtt.String()
+			}
+		}
+		result[alias] = info
+	}
+
+	return result
+}
+
+func (a *AdminAPI) subscriptions() map[string][]common.SubscriptionInfo {
+	result := make(map[string][]common.SubscriptionInfo, len(a.app.Servers))
+	for alias, server := range a.app.Servers {
+		infos := make([]common.SubscriptionInfo, 0, len(server.Handlers))
+		for _, handler := range server.Handlers {
+			infos = append(infos, handler.SubscriptionInfo())
+		}
+		result[alias] = infos
+	}
+
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("encoding response: %v", err),
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+	_ caddy.Provisioner = (*AdminAPI)(nil)
+)
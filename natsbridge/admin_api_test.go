@@ -0,0 +1,71 @@
+package natsbridge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/nats-io/nats.go"
+)
+
+type stubHandler struct {
+	info common.SubscriptionInfo
+}
+
+func (h stubHandler) Subscribe(string, common.ServerDefaults, *nats.Conn) error { return nil }
+func (h stubHandler) Unsubscribe(context.Context, *nats.Conn) error             { return nil }
+func (h stubHandler) SubscriptionInfo() common.SubscriptionInfo                 { return h.info }
+
+func TestAdminAPI(t *testing.T) {
+	a := &AdminAPI{
+		app: &NatsBridgeApp{
+			Servers: map[string]*NatsServer{
+				"default": {
+					Handlers: []common.NatsHandler{
+						stubHandler{info: common.SubscriptionInfo{Subject: "foo.>", QueueGroup: "q"}},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("servers which are not started yet are reported", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		err := a.handleAPIEndpoints(rec, httptest.NewRequest(http.MethodGet, "/nats/servers", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var servers map[string]serverInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &servers); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if servers["default"].Status != "NOT_STARTED" {
+			t.Errorf("expected status NOT_STARTED, got %+v", servers)
+		}
+	})
+
+	t.Run("subscriptions are reported per server alias", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		err := a.handleAPIEndpoints(rec, httptest.NewRequest(http.MethodGet, "/nats/subscriptions", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var subscriptions map[string][]common.SubscriptionInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &subscriptions); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		if len(subscriptions["default"]) != 1 || subscriptions["default"][0].Subject != "foo.>" || subscriptions["default"][0].QueueGroup != "q" {
+			t.Errorf("unexpected subscriptions: %+v", subscriptions)
+		}
+	})
+
+	t.Run("unknown resources return an error", func(t *testing.T) {
+		err := a.handleAPIEndpoints(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nats/foo", nil))
+		if err == nil {
+			t.Errorf("expected an error, but got none")
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package natsbridge
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// validateAuth ensures that at most one authentication method is configured for the server; otherwise it would be
+// unclear which one is used when connecting. Proactive reconnects after credential rotation are rejected for NKeys:
+// the connection keeps the NKey it was created with, so a rotated NKey is only used after a reload of Caddy.
+func (server *NatsServer) validateAuth() error {
+	var methods []string
+	if server.JWT != "" || server.Seed != "" {
+		if server.JWT == "" || server.Seed == "" {
+			return fmt.Errorf("jwt and seed must be specified together")
+		}
+		methods = append(methods, "jwt/seed")
+	}
+	if server.UserCredentialFile != "" {
+		methods = append(methods, "userCredentialFile")
+	}
+	if server.NkeyCredentialFile != "" {
+		methods = append(methods, "nkeyCredentialFile")
+	}
+	if server.User != "" || server.Password != "" {
+		if server.User == "" {
+			return fmt.Errorf("password requires user to be specified")
+		}
+		methods = append(methods, "user/password")
+	}
+	if server.Token != "" {
+		methods = append(methods, "token")
+	}
+	if server.TokenFile != "" {
+		methods = append(methods, "tokenFile")
+	}
+
+	if len(methods) > 1 {
+		return fmt.Errorf("only one authentication method may be specified, but found: %s", strings.Join(methods, ", "))
+	}
+	if server.NkeyCredentialFile != "" && server.CredentialReconnectGrace != nil {
+		return fmt.Errorf("credentialReconnectGrace cannot be used with nkeyCredentialFile: a rotated NKey requires a reload of Caddy")
+	}
+
+	return nil
+}
+
+// authOptions converts the configured authentication method to options for nats.Connect.
+// validateAuth must have been called before.
+func (server *NatsServer) authOptions() ([]nats.Option, error) {
+	switch {
+	case server.JWT != "":
+		return []nats.Option{nats.UserJWTAndSeed(server.JWT, server.Seed)}, nil
+	case server.UserCredentialFile != "":
+		// JWT; nats.go reads the file on every (re)connect, so a rotated file is picked up automatically.
+		return []nats.Option{nats.UserCredentials(server.UserCredentialFile)}, nil
+	case server.NkeyCredentialFile != "":
+		// NKEY
+		opt, err := nkeyOption(server.NkeyCredentialFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load NKey from %s: %w", server.NkeyCredentialFile, err)
+		}
+		return []nats.Option{opt}, nil
+	case server.User != "":
+		return []nats.Option{nats.UserInfo(server.User, server.Password)}, nil
+	case server.Token != "":
+		return []nats.Option{nats.Token(server.Token)}, nil
+	case server.TokenFile != "":
+		// the token file is read on every (re)connect, so a rotated token is picked up automatically.
+		tokenFile := server.TokenFile
+		if _, err := readTokenFile(tokenFile); err != nil {
+			return nil, err
+		}
+		return []nats.Option{nats.TokenHandler(func() string {
+			token, _ := readTokenFile(tokenFile)
+			return token
+		})}, nil
+	}
+
+	return nil, nil
+}
+
+// nkeyOption reads the NKey seed once, and signs all (re)connects with it. nats.NkeyOptionFromSeed re-reads the
+// seed file on every reconnect, but keeps the public key of the initial connect; so after the file was rotated,
+// the signature would not match the public key anymore. A rotated NKey is used by the new connection of the next
+// config reload instead, see connectionKey.
+func nkeyOption(seedFile string) (nats.Option, error) {
+	b, err := os.ReadFile(seedFile)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := nkeys.ParseDecoratedNKey(b)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if !nkeys.IsValidPublicUserKey(pub) {
+		return nil, fmt.Errorf("not a valid NKey user seed")
+	}
+	return nats.Nkey(pub, kp.Sign), nil
+}
+
+func readTokenFile(tokenFile string) (string, error) {
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read token from %s: %w", tokenFile, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
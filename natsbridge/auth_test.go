@@ -0,0 +1,80 @@
+package natsbridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateAuth(t *testing.T) {
+	grace := 30 * time.Second
+	tests := []struct {
+		name        string
+		server      NatsServer
+		expectError bool
+	}{
+		{
+			name:   "no authentication",
+			server: NatsServer{},
+		},
+		{
+			name:   "user and password",
+			server: NatsServer{User: "user", Password: "secret"},
+		},
+		{
+			name:   "token",
+			server: NatsServer{Token: "s3cr3t"},
+		},
+		{
+			name:   "tokenFile",
+			server: NatsServer{TokenFile: "/my/token"},
+		},
+		{
+			name:   "jwt and seed",
+			server: NatsServer{JWT: "jwt", Seed: "seed"},
+		},
+		{
+			name:        "password without user",
+			server:      NatsServer{Password: "secret"},
+			expectError: true,
+		},
+		{
+			name:        "jwt without seed",
+			server:      NatsServer{JWT: "jwt"},
+			expectError: true,
+		},
+		{
+			name:        "user and token",
+			server:      NatsServer{User: "user", Password: "secret", Token: "s3cr3t"},
+			expectError: true,
+		},
+		{
+			name:        "token and tokenFile",
+			server:      NatsServer{Token: "s3cr3t", TokenFile: "/my/token"},
+			expectError: true,
+		},
+		{
+			name:        "userCredentialFile and nkeyCredentialFile",
+			server:      NatsServer{UserCredentialFile: "/my/creds", NkeyCredentialFile: "/my/nkey"},
+			expectError: true,
+		},
+		{
+			name:        "nkeyCredentialFile with credentialReconnectGrace",
+			server:      NatsServer{NkeyCredentialFile: "/my/nkey", CredentialReconnectGrace: &grace},
+			expectError: true,
+		},
+	}
+
+	for i := range tests {
+		// NatsServer must not be copied, as it contains locks.
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.server.validateAuth()
+			if tt.expectError && err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
@@ -2,11 +2,15 @@ package natsbridge
 
 import (
 	"encoding/json"
+	"time"
 
+	"github.com/CoverWhale/caddy-nats-bridge/common"
 	"github.com/CoverWhale/caddy-nats-bridge/subscribe"
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/dustin/go-humanize"
 )
 
 func ParseGobalNatsOption(d *caddyfile.Dispenser, existingVal interface{}) (interface{}, error) {
@@ -55,6 +59,14 @@ func (app *NatsBridgeApp) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.AllArgs(&server.NatsUrl) {
 					return d.ArgErr()
 				}
+			case "context":
+				server.Context = ContextSelected
+				if d.NextArg() {
+					server.Context = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
 			case "jwt":
 				if !d.AllArgs(&server.JWT) {
 					return d.ArgErr()
@@ -63,6 +75,22 @@ func (app *NatsBridgeApp) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.AllArgs(&server.Seed) {
 					return d.ArgErr()
 				}
+			case "user":
+				if !d.AllArgs(&server.User) {
+					return d.ArgErr()
+				}
+			case "password":
+				if !d.AllArgs(&server.Password) {
+					return d.ArgErr()
+				}
+			case "token":
+				if !d.AllArgs(&server.Token) {
+					return d.ArgErr()
+				}
+			case "tokenFile":
+				if !d.AllArgs(&server.TokenFile) {
+					return d.ArgErr()
+				}
 			case "userCredentialFile":
 				if !d.AllArgs(&server.UserCredentialFile) {
 					return d.ArgErr()
@@ -71,6 +99,16 @@ func (app *NatsBridgeApp) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.AllArgs(&server.NkeyCredentialFile) {
 					return d.ArgErr()
 				}
+			case "credential_watch_interval":
+				if err := parseDuration(d, &server.CredentialWatchInterval); err != nil {
+					return err
+				}
+			case "credential_reconnect_grace":
+				var grace time.Duration
+				if err := parseDuration(d, &grace); err != nil {
+					return err
+				}
+				server.CredentialReconnectGrace = &grace
 			case "clientName":
 				if !d.AllArgs(&server.ClientName) {
 					return d.ArgErr()
@@ -79,6 +117,84 @@ func (app *NatsBridgeApp) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.AllArgs(&server.InboxPrefix) {
 					return d.ArgErr()
 				}
+			case "tls":
+				if server.TLS == nil {
+					server.TLS = &TLSConfig{}
+				}
+				if err := server.TLS.unmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "jetstream":
+				if server.JetStreamConfig == nil {
+					server.JetStreamConfig = &JetStreamConfig{}
+				}
+				if err := server.JetStreamConfig.unmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "embedded":
+				// the embedded server belongs to the whole app, and not to the server alias.
+				if app.Embedded != nil {
+					return d.Err("embedded is already configured in another nats option; it must only be specified once")
+				}
+				app.Embedded = &EmbeddedServer{}
+				if err := app.Embedded.unmarshalCaddyfile(d); err != nil {
+					return err
+				}
+			case "default_timeout":
+				var timeout time.Duration
+				if err := parseDuration(d, &timeout); err != nil {
+					return err
+				}
+				server.DefaultTimeout = &timeout
+			case "subject_prefix":
+				if !d.AllArgs(&server.SubjectPrefix) {
+					return d.ArgErr()
+				}
+			case "header":
+				if err := common.ParseHeader(d, &server.Headers); err != nil {
+					return err
+				}
+			case "drain_timeout":
+				// the drain timeout applies to all servers; so it can be specified in any nats option, but only
+				// with the same value.
+				var timeout time.Duration
+				if err := parseDuration(d, &timeout); err != nil {
+					return err
+				}
+				if app.DrainTimeout != 0 && app.DrainTimeout != timeout {
+					return d.Errf("drain_timeout %s conflicts with drain_timeout %s of another nats option", timeout, app.DrainTimeout)
+				}
+				app.DrainTimeout = timeout
+			case "connect_mode":
+				if !d.AllArgs(&server.ConnectMode) {
+					return d.ArgErr()
+				}
+			case "disconnected_status":
+				if err := common.ParseInt(d, &server.DisconnectedStatus); err != nil {
+					return err
+				}
+			case "reconnect_wait":
+				if err := parseDuration(d, &server.ReconnectWait); err != nil {
+					return err
+				}
+			case "reconnect_jitter":
+				if err := parseDuration(d, &server.ReconnectJitter); err != nil {
+					return err
+				}
+			case "reconnect_buffer_size":
+				size, err := parseSize(d)
+				if err != nil {
+					return err
+				}
+				server.ReconnectBufferSize = size
+			case "ping_interval":
+				if err := parseDuration(d, &server.PingInterval); err != nil {
+					return err
+				}
+			case "max_pings_outstanding":
+				if err := common.ParseInt(d, &server.MaxPingsOutstanding); err != nil {
+					return err
+				}
 			case "subscribe":
 				s, err := subscribe.ParseSubscribeHandler(d)
 				if err != nil {
@@ -94,3 +210,221 @@ func (app *NatsBridgeApp) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 	return nil
 }
+
+func parseDuration(d *caddyfile.Dispenser, target *time.Duration) error {
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	dur, err := caddy.ParseDuration(d.Val())
+	if err != nil {
+		return d.Errf("%s is not a valid duration: %v", d.Val(), err)
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	*target = dur
+	return nil
+}
+
+// parseSize parses a size like "8MB" or "512KiB"; "-1" is allowed as well.
+func parseSize(d *caddyfile.Dispenser) (int, error) {
+	var val string
+	if !d.AllArgs(&val) {
+		return 0, d.ArgErr()
+	}
+	if val == "-1" {
+		return -1, nil
+	}
+	size, err := humanize.ParseBytes(val)
+	if err != nil {
+		return 0, d.Errf("%s is not a valid size: %v", val, err)
+	}
+	return int(size), nil
+}
+
+// unmarshalCaddyfile parses the jetstream block of a nats server. Syntax:
+//
+//	jetstream {
+//	    [domain <domain>]
+//	    [api_prefix <prefix>]
+//	    [default_timeout <duration>]
+//	}
+func (c *JetStreamConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "domain":
+			if !d.AllArgs(&c.Domain) {
+				return d.ArgErr()
+			}
+		case "api_prefix":
+			if !d.AllArgs(&c.APIPrefix) {
+				return d.ArgErr()
+			}
+		case "default_timeout":
+			if err := parseDuration(d, &c.DefaultTimeout); err != nil {
+				return err
+			}
+		default:
+			return d.Errf("unrecognized jetstream subdirective: %s", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfile parses the tls block of a nats server. Syntax:
+//
+//	tls {
+//	    [ca <pem_files...>]
+//	    [cert <pem_file>]
+//	    [key <pem_file>]
+//	    [client_certificate <subject>]
+//	    [server_name <name>]
+//	    [insecure_skip_verify]
+//	    [handshake_first]
+//	}
+func (t *TLSConfig) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "ca":
+			t.CA = append(t.CA, d.RemainingArgs()...)
+			if len(t.CA) == 0 {
+				return d.ArgErr()
+			}
+		case "cert":
+			if !d.AllArgs(&t.Cert) {
+				return d.ArgErr()
+			}
+		case "key":
+			if !d.AllArgs(&t.Key) {
+				return d.ArgErr()
+			}
+		case "client_certificate":
+			if !d.AllArgs(&t.ClientCertificate) {
+				return d.ArgErr()
+			}
+		case "server_name":
+			if !d.AllArgs(&t.ServerName) {
+				return d.ArgErr()
+			}
+		case "insecure_skip_verify":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			t.InsecureSkipVerify = true
+		case "handshake_first":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			t.HandshakeFirst = true
+		default:
+			return d.Errf("unrecognized tls subdirective: %s", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfile parses the embedded block of the nats app. Syntax:
+//
+//	embedded {
+//	    [server_name <name>]
+//	    [listen <host:port>]
+//	    [jetstream [<store_dir>]]
+//	    [leafnode_remote <urls> {
+//	        [account <name>]
+//	        [credentials <creds_file>]
+//	    }]
+//	    [account <name> {
+//	        [user <user> <password>]
+//	    }]
+//	    [ready_timeout <duration>]
+//	}
+func (e *EmbeddedServer) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "server_name":
+			if !d.AllArgs(&e.ServerName) {
+				return d.ArgErr()
+			}
+		case "listen":
+			if !d.AllArgs(&e.Listen) {
+				return d.ArgErr()
+			}
+		case "jetstream":
+			e.JetStream = true
+			if d.NextArg() {
+				e.StoreDir = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		case "leafnode_remote":
+			remote := &LeafNodeRemote{}
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			remote.URL = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			for remoteNesting := d.Nesting(); d.NextBlock(remoteNesting); {
+				switch d.Val() {
+				case "account":
+					if !d.AllArgs(&remote.Account) {
+						return d.ArgErr()
+					}
+				case "credentials":
+					if !d.AllArgs(&remote.Credentials) {
+						return d.ArgErr()
+					}
+				default:
+					return d.Errf("unrecognized leafnode_remote subdirective: %s", d.Val())
+				}
+			}
+			e.LeafNodeRemotes = append(e.LeafNodeRemotes, remote)
+		case "account":
+			account := &EmbeddedAccount{}
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			account.Name = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			for accountNesting := d.Nesting(); d.NextBlock(accountNesting); {
+				switch d.Val() {
+				case "user":
+					user := &EmbeddedUser{}
+					if !d.AllArgs(&user.User, &user.Password) {
+						return d.ArgErr()
+					}
+					account.Users = append(account.Users, user)
+				default:
+					return d.Errf("unrecognized account subdirective: %s", d.Val())
+				}
+			}
+			e.Accounts = append(e.Accounts, account)
+		case "ready_timeout":
+			if err := parseDuration(d, &e.ReadyTimeout); err != nil {
+				return err
+			}
+		default:
+			return d.Errf("unrecognized embedded subdirective: %s", d.Val())
+		}
+	}
+
+	return nil
+}
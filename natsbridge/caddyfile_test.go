@@ -0,0 +1,55 @@
+package natsbridge
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestParseGlobalNatsOptionTwice(t *testing.T) {
+	tests := []struct {
+		name        string
+		first       string
+		second      string
+		expectError bool
+	}{
+		{
+			name:   "embedded in one option",
+			first:  "nats {\n embedded\n}",
+			second: "nats other {\n url nats://127.0.0.1:4222\n}",
+		},
+		{
+			name:        "embedded in two options",
+			first:       "nats {\n embedded\n}",
+			second:      "nats other {\n embedded {\n  listen 127.0.0.1:4222\n }\n}",
+			expectError: true,
+		},
+		{
+			name:   "same drain_timeout in two options",
+			first:  "nats {\n drain_timeout 10s\n}",
+			second: "nats other {\n drain_timeout 10s\n}",
+		},
+		{
+			name:        "conflicting drain_timeout in two options",
+			first:       "nats {\n drain_timeout 10s\n}",
+			second:      "nats other {\n drain_timeout 20s\n}",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, err := ParseGobalNatsOption(caddyfile.NewTestDispenser(tt.first), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = ParseGobalNatsOption(caddyfile.NewTestDispenser(tt.second), existing)
+			if tt.expectError && err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package natsbridge
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// connPool keeps NATS connections alive across config reloads. On a reload, the new config is started before the
+// old one is stopped; so a server whose connection options did not change re-uses the connection of the old config.
+// The connection is only closed when the last config using it is stopped.
+var connPool = caddy.NewUsagePool()
+
+// pooledConn is a NATS connection shared between configs.
+type pooledConn struct {
+	conn          *nats.Conn
+	lastReconnect atomic.Pointer[time.Time]
+	// connected is set once the connection was established for the first time; afterwards, nats.go re-creates
+	// the subscriptions on every reconnect, so handlers can subscribe even while the connection is reconnecting.
+	connected atomic.Bool
+	// closed is closed by the ClosedHandler, f.e. once draining the connection is complete.
+	closed      chan struct{}
+	credentials *credentialWatcher
+
+	mu sync.Mutex
+	// owners are all servers currently using the connection, in the order they were started. All connection
+	// callbacks are delegated to the most recently started one.
+	owners []*connOwner
+}
+
+type connOwner struct {
+	app    *NatsBridgeApp
+	alias  string
+	server *NatsServer
+	logger *zap.Logger
+}
+
+func (pc *pooledConn) owner() *connOwner {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.owners[len(pc.owners)-1]
+}
+
+func (pc *pooledConn) addOwner(owner *connOwner) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.owners = append(pc.owners, owner)
+}
+
+// removeOwner hands the connection back to the previous owner; f.e. if a config reload failed and the old config
+// keeps running.
+func (pc *pooledConn) removeOwner(server *NatsServer) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	owners := slices.DeleteFunc(slices.Clone(pc.owners), func(o *connOwner) bool { return o.server == server })
+	if len(owners) > 0 {
+		pc.owners = owners
+	}
+}
+
+// Destruct drains the connection, so all pending messages of its subscriptions are handled and all buffered
+// messages are flushed. If draining does not complete within the drain timeout, the connection is closed.
+func (pc *pooledConn) Destruct() error {
+	pc.stopWatchingCredentials()
+	owner := pc.owner()
+	if err := pc.conn.Drain(); err != nil {
+		// f.e. if the connection is currently not established.
+		owner.logger.Info("could not drain NATS connection, closing it", zap.Error(err))
+		pc.conn.Close()
+		return nil
+	}
+
+	select {
+	case <-pc.closed:
+	case <-time.After(owner.app.DrainTimeout):
+		owner.logger.Warn("NATS connection not drained in time, closing it",
+			zap.Duration("drainTimeout", owner.app.DrainTimeout),
+			zap.Int("buffered_bytes", bufferedBytes(pc.conn)))
+		pc.conn.Close()
+	}
+	return nil
+}
+
+func bufferedBytes(conn *nats.Conn) int {
+	buffered, err := conn.Buffered()
+	if err != nil {
+		return 0
+	}
+	return buffered
+}
+
+// connectionKey identifies a pooled connection by the alias, the URL or the embedded server it connects to
+// in-process, and all options which are relevant for nats.Connect. The NKey is read once per connection, so the
+// content of the NKey file is part of the key as well; a reload after the NKey was rotated creates a new connection.
+func (server *NatsServer) connectionKey(alias string, natsUrl string, inProcess string) (string, error) {
+	var nkeyHash string
+	if server.NkeyCredentialFile != "" {
+		hash, err := hashFile(server.NkeyCredentialFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read NKey from %s: %w", server.NkeyCredentialFile, err)
+		}
+		nkeyHash = hex.EncodeToString(hash[:])
+	}
+
+	key, err := json.Marshal(struct {
+		Alias               string
+		NatsUrl             string
+		InProcess           string
+		UserCredentialFile  string
+		NkeyCredentialFile  string
+		NkeyHash            string
+		JWT                 string
+		Seed                string
+		User                string
+		Password            string
+		Token               string
+		TokenFile           string
+		ClientName          string
+		InboxPrefix         string
+		TLS                 *TLSConfig
+		ConnectMode         string
+		ReconnectWait       time.Duration
+		ReconnectJitter     time.Duration
+		ReconnectBufferSize int
+		PingInterval        time.Duration
+		MaxPingsOutstanding int
+	}{
+		alias,
+		natsUrl,
+		inProcess,
+		server.UserCredentialFile,
+		server.NkeyCredentialFile,
+		nkeyHash,
+		server.JWT,
+		server.Seed,
+		server.User,
+		server.Password,
+		server.Token,
+		server.TokenFile,
+		server.ClientName,
+		server.InboxPrefix,
+		server.TLS,
+		server.ConnectMode,
+		server.ReconnectWait,
+		server.ReconnectJitter,
+		server.ReconnectBufferSize,
+		server.PingInterval,
+		server.MaxPingsOutstanding,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not build connection key: %w", err)
+	}
+	return string(key), nil
+}
+
+// connect connects the server to NATS (or re-uses the pooled connection of a previous config), and subscribes
+// all its handlers.
+func (app *NatsBridgeApp) connect(alias string, server *NatsServer) error {
+	logger := app.logger.With(zap.String("serverAlias", alias))
+	owner := &connOwner{app: app, alias: alias, server: server, logger: logger}
+
+	// servers without url connect in-process to the embedded server; the connection is bound to the embedded
+	// server instance, so a restarted embedded server gets a new connection.
+	target, inProcess := server.NatsUrl, ""
+	embedded := app.embeddedServer(server)
+	if embedded != nil {
+		target, inProcess = "embedded NATS server", embedded.srv.ID()
+	}
+	key, err := server.connectionKey(alias, server.NatsUrl, inProcess)
+	if err != nil {
+		return err
+	}
+
+	val, loaded, err := connPool.LoadOrNew(key, func() (caddy.Destructor, error) {
+		pc := &pooledConn{closed: make(chan struct{})}
+		pc.addOwner(owner)
+
+		// Connect to the NATS server
+		if embedded != nil {
+			logger.Info("connecting in-process to the embedded NATS server")
+		} else {
+			logger.Info("connecting via NATS URL: ", zap.String("natsUrl", server.NatsUrl))
+		}
+
+		opts, err := server.connectOptions(pc)
+		if err != nil {
+			return nil, err
+		}
+		if embedded != nil {
+			opts = append(opts, embedded.connectOption())
+		}
+		pc.conn, err = nats.Connect(server.NatsUrl, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to %s : %w", target, err)
+		}
+		if pc.conn.IsConnected() {
+			pc.connected.Store(true)
+		}
+		pc.watchCredentials(server)
+		return pc, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pc := val.(*pooledConn)
+	server.pooled = pc
+	server.poolKey = key
+	server.Conn = pc.conn
+
+	if loaded {
+		pc.addOwner(owner)
+		logger.Info("re-using NATS connection of previous config", zap.String("url", pc.conn.ConnectedUrlRedacted()))
+	}
+
+	if server.ConnectMode == ConnectModeAsync && !pc.connected.Load() {
+		// the handlers subscribe as soon as the initial connection is established, see connectOptions. This is
+		// only done for connections which never connected: the connect handler is not called on reconnects.
+		if !loaded {
+			logger.Warn("NATS server not reachable yet, connecting in background", zap.String("natsUrl", target))
+		}
+		return nil
+	}
+
+	if !loaded {
+		logger.Info("connected to NATS server", zap.String("url", pc.conn.ConnectedUrlRedacted()))
+	}
+	return server.subscribeHandlers(alias, pc.conn)
+}
+
+// connectOptions builds all options for nats.Connect.
+func (server *NatsServer) connectOptions(pc *pooledConn) ([]nats.Option, error) {
+	opts, err := server.authOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if server.ClientName != "" {
+		opts = append(opts, nats.Name(server.ClientName))
+	}
+	if server.InboxPrefix != "" {
+		opts = append(opts, nats.CustomInboxPrefix(server.InboxPrefix))
+	}
+
+	if server.TLS != nil {
+		opts = append(opts, server.TLS.natsOptions()...)
+	}
+
+	opts = append(opts, server.reconnectOptions()...)
+	opts = append(opts, pc.lifecycleOptions()...)
+	opts = append(opts, nats.DrainTimeout(pc.owner().app.DrainTimeout))
+
+	if server.ConnectMode == ConnectModeAsync {
+		// do not block Caddy startup; the handlers subscribe as soon as the initial connection is established.
+		opts = append(opts,
+			nats.RetryOnFailedConnect(true),
+			nats.ConnectHandler(func(conn *nats.Conn) {
+				// connected must be set before the owner is read, so a config started concurrently either
+				// subscribes itself in connect(), or is the owner subscribed here.
+				pc.connected.Store(true)
+				owner := pc.owner()
+				owner.logger.Info("connected to NATS server", zap.String("url", conn.ConnectedUrlRedacted()))
+				if err := owner.server.subscribeHandlers(owner.alias, conn); err != nil {
+					owner.logger.Error("could not subscribe handler", zap.Error(err))
+				}
+			}),
+		)
+	}
+
+	return opts, nil
+}
+
+// subscribeHandlers subscribes all handlers of the server exactly once; it might be called concurrently from
+// connect() and the connect handler in async connect mode.
+func (server *NatsServer) subscribeHandlers(alias string, conn *nats.Conn) error {
+	var err error
+	server.subscribeOnce.Do(func() {
+		for _, handler := range server.Handlers {
+			err = handler.Subscribe(alias, server.Defaults(), conn)
+			if err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
+
+// disconnect releases the server's pooled connection; it is closed if no other config uses it anymore.
+func (server *NatsServer) disconnect(logger *zap.Logger) error {
+	if server.pooled == nil {
+		return nil
+	}
+
+	url := server.Conn.ConnectedUrlRedacted()
+	server.pooled.removeOwner(server)
+	deleted, err := connPool.Delete(server.poolKey)
+	server.pooled = nil
+	if deleted {
+		logger.Info("closed NATS connection", zap.String("url", url))
+	} else {
+		logger.Info("keeping NATS connection for new config", zap.String("url", url))
+	}
+	return err
+}
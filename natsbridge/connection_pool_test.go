@@ -0,0 +1,242 @@
+package natsbridge
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// countingHandler counts how often it was subscribed.
+type countingHandler struct {
+	subscribed *atomic.Int32
+}
+
+func (h countingHandler) Subscribe(string, common.ServerDefaults, *nats.Conn) error {
+	h.subscribed.Add(1)
+	return nil
+}
+func (h countingHandler) Unsubscribe(context.Context, *nats.Conn) error { return nil }
+func (h countingHandler) SubscriptionInfo() common.SubscriptionInfo     { return common.SubscriptionInfo{} }
+
+func TestConnectionKey(t *testing.T) {
+	base := func() *NatsServer {
+		return &NatsServer{NatsUrl: "nats://127.0.0.1:4222", User: "user", Password: "secret"}
+	}
+
+	tests := []struct {
+		name       string
+		alias      string
+		server     func() *NatsServer
+		inProcess  string
+		expectSame bool
+	}{
+		{
+			name:       "unchanged",
+			alias:      "default",
+			server:     base,
+			expectSame: true,
+		},
+		{
+			name:  "handler-only settings do not matter",
+			alias: "default",
+			server: func() *NatsServer {
+				s := base()
+				timeout := 5 * time.Second
+				s.DefaultTimeout = &timeout
+				s.DisconnectedStatus = 502
+				s.SubjectPrefix = "tenant-a."
+				s.Headers = map[string]string{"X-Tenant": "a"}
+				s.JetStreamConfig = &JetStreamConfig{Domain: "hub"}
+				return s
+			},
+			expectSame: true,
+		},
+		{
+			name:   "different alias",
+			alias:  "other",
+			server: base,
+		},
+		{
+			name:  "different url",
+			alias: "default",
+			server: func() *NatsServer {
+				s := base()
+				s.NatsUrl = "nats://127.0.0.1:4223"
+				return s
+			},
+		},
+		{
+			name:  "different password",
+			alias: "default",
+			server: func() *NatsServer {
+				s := base()
+				s.Password = "changed"
+				return s
+			},
+		},
+		{
+			name:      "in-process connection to the embedded server",
+			alias:     "default",
+			server:    base,
+			inProcess: "NDEMBEDDED",
+		},
+		{
+			name:  "different reconnect tuning",
+			alias: "default",
+			server: func() *NatsServer {
+				s := base()
+				s.ReconnectWait = time.Second
+				return s
+			},
+		},
+	}
+
+	expected, err := base().connectionKey("default", "nats://127.0.0.1:4222", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server()
+			key, err := server.connectionKey(tt.alias, server.NatsUrl, tt.inProcess)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if same := key == expected; same != tt.expectSame {
+				t.Errorf("expected same key: %v, got: %v", tt.expectSame, same)
+			}
+		})
+	}
+}
+
+func TestReloadWhileReconnecting(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+
+	appWithHandler := func() (*NatsBridgeApp, *atomic.Int32) {
+		subscribed := &atomic.Int32{}
+		app := &NatsBridgeApp{
+			DrainTimeout: time.Second,
+			Servers: map[string]*NatsServer{
+				"default": {
+					NatsUrl:       srv.ClientURL(),
+					ConnectMode:   ConnectModeAsync,
+					ReconnectWait: time.Hour,
+					Handlers:      []common.NatsHandler{countingHandler{subscribed: subscribed}},
+				},
+			},
+			logger: zap.NewNop(),
+		}
+		return app, subscribed
+	}
+
+	oldApp, oldSubscribed := appWithHandler()
+	if err := oldApp.connect("default", oldApp.Servers["default"]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for oldSubscribed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if oldSubscribed.Load() != 1 {
+		t.Fatalf("expected the handler of the old config to be subscribed")
+	}
+
+	srv.Shutdown()
+	conn := oldApp.Servers["default"].Conn
+	for !conn.IsReconnecting() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !conn.IsReconnecting() {
+		t.Fatalf("expected the connection to be reconnecting")
+	}
+
+	// the new config re-uses the reconnecting connection, whose connect handler is not called anymore.
+	newApp, newSubscribed := appWithHandler()
+	if err := newApp.connect("default", newApp.Servers["default"]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newApp.Servers["default"].Conn != conn {
+		t.Fatalf("expected the pooled connection to be re-used")
+	}
+	if newSubscribed.Load() != 1 {
+		t.Errorf("expected the handler of the new config to be subscribed, got %d subscriptions", newSubscribed.Load())
+	}
+
+	if err := oldApp.Servers["default"].disconnect(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := newApp.Servers["default"].disconnect(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAsyncConnect(t *testing.T) {
+	// reserve a port the NATS server is started on later.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	subscribed := &atomic.Int32{}
+	app := &NatsBridgeApp{
+		DrainTimeout: time.Second,
+		Servers: map[string]*NatsServer{
+			"default": {
+				NatsUrl:            fmt.Sprintf("nats://127.0.0.1:%d", port),
+				ConnectMode:        ConnectModeAsync,
+				DisconnectedStatus: 502,
+				ReconnectWait:      10 * time.Millisecond,
+				Handlers:           []common.NatsHandler{countingHandler{subscribed: subscribed}},
+			},
+		},
+	}
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("expected provisioning to succeed without NATS server, but got: %v", err)
+	}
+	if err := app.Start(); err != nil {
+		t.Fatalf("expected start to succeed without NATS server, but got: %v", err)
+	}
+	defer app.Stop()
+
+	server := app.Servers["default"]
+	if !server.Unavailable() {
+		t.Errorf("expected the server to be unavailable")
+	}
+	if subscribed.Load() != 0 {
+		t.Errorf("expected the handler not to be subscribed before the connection is established")
+	}
+
+	opts := natsserver.DefaultTestOptions
+	opts.Port = port
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for subscribed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if subscribed.Load() != 1 {
+		t.Errorf("expected the handler to be subscribed once after connecting, got %d subscriptions", subscribed.Load())
+	}
+	if server.Unavailable() {
+		t.Errorf("expected the server to be available after connecting")
+	}
+}
@@ -0,0 +1,150 @@
+package natsbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ContextSelected is the value of NatsServer.Context which loads the context currently selected with
+// "nats context select".
+const ContextSelected = "@selected"
+
+// natsContext is the subset of a NATS CLI context file (see "nats context") which is relevant for connecting.
+type natsContext struct {
+	URL         string `json:"url"`
+	Token       string `json:"token"`
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Creds       string `json:"creds"`
+	NKey        string `json:"nkey"`
+	Cert        string `json:"cert"`
+	Key         string `json:"key"`
+	CA          string `json:"ca"`
+	NSCLookup   string `json:"nsc"`
+	UserJWT     string `json:"user_jwt"`
+	SocksProxy  string `json:"socks_proxy"`
+	InboxPrefix string `json:"inbox_prefix"`
+	TLSFirst    bool   `json:"tls_first"`
+}
+
+// natsConfigDir returns the directory the NATS CLI stores its configuration in.
+func natsConfigDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "nats"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "nats"), nil
+}
+
+// loadNatsContext reads the NATS CLI context with the given name, or the selected one for ContextSelected.
+func loadNatsContext(name string) (*natsContext, error) {
+	dir, err := natsConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == ContextSelected {
+		selected, err := os.ReadFile(filepath.Join(dir, "context.txt"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read selected NATS context: %w", err)
+		}
+		name = strings.TrimSpace(string(selected))
+		if name == "" {
+			return nil, fmt.Errorf("no NATS context selected")
+		}
+	}
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return nil, fmt.Errorf("invalid NATS context name %s", name)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "context", name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read NATS context %s: %w", name, err)
+	}
+	nctx := &natsContext{}
+	if err := json.Unmarshal(b, nctx); err != nil {
+		return nil, fmt.Errorf("could not parse NATS context %s: %w", name, err)
+	}
+
+	switch {
+	case nctx.NSCLookup != "":
+		return nil, fmt.Errorf("NATS context %s: nsc lookups are not supported", name)
+	case nctx.UserJWT != "":
+		return nil, fmt.Errorf("NATS context %s: user_jwt is not supported, use creds instead", name)
+	case nctx.SocksProxy != "":
+		return nil, fmt.Errorf("NATS context %s: socks_proxy is not supported", name)
+	}
+
+	return nctx, nil
+}
+
+// applyContext fills in all connection options of the server which are not configured explicitly from its NATS CLI
+// context. The authentication of the context is only used if no authentication method is configured explicitly.
+func (server *NatsServer) applyContext() error {
+	if server.Context == "" {
+		return nil
+	}
+	nctx, err := loadNatsContext(server.Context)
+	if err != nil {
+		return err
+	}
+
+	setIfEmpty(&server.NatsUrl, nctx.URL)
+	setIfEmpty(&server.InboxPrefix, nctx.InboxPrefix)
+
+	if !server.hasAuth() {
+		server.Token = nctx.Token
+		server.User = nctx.User
+		server.Password = nctx.Password
+		server.UserCredentialFile = expandHome(nctx.Creds)
+		server.NkeyCredentialFile = expandHome(nctx.NKey)
+	}
+
+	if nctx.Cert != "" || nctx.Key != "" || nctx.CA != "" || nctx.TLSFirst {
+		if server.TLS == nil {
+			server.TLS = &TLSConfig{}
+		}
+		if server.TLS.Cert == "" && server.TLS.ClientCertificate == "" {
+			setIfEmpty(&server.TLS.Cert, expandHome(nctx.Cert))
+			setIfEmpty(&server.TLS.Key, expandHome(nctx.Key))
+		}
+		if len(server.TLS.CA) == 0 && nctx.CA != "" {
+			server.TLS.CA = []string{expandHome(nctx.CA)}
+		}
+		if nctx.TLSFirst {
+			server.TLS.HandshakeFirst = true
+		}
+	}
+
+	return nil
+}
+
+// hasAuth returns true if any authentication method is configured.
+func (server *NatsServer) hasAuth() bool {
+	return server.JWT != "" || server.Seed != "" || server.UserCredentialFile != "" || server.NkeyCredentialFile != "" ||
+		server.User != "" || server.Password != "" || server.Token != "" || server.TokenFile != ""
+}
+
+func setIfEmpty(target *string, val string) {
+	if *target == "" {
+		*target = val
+	}
+}
+
+// expandHome expands a leading "~" like the NATS CLI does for the paths in a context.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
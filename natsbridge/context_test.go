@@ -0,0 +1,116 @@
+package natsbridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeNatsContext(t *testing.T, name string, content string) {
+	t.Helper()
+	dir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "nats", "context")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestApplyContext(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	writeNatsContext(t, "prod", `{
+		"url": "nats://prod:4222",
+		"creds": "/path/to/prod.creds",
+		"ca": "/path/to/ca.pem",
+		"inbox_prefix": "_INBOX_prod"
+	}`)
+	writeNatsContext(t, "nsc", `{"url": "nats://nsc:4222", "nsc": "nsc://operator/account/user"}`)
+	err := os.WriteFile(filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "nats", "context.txt"), []byte("prod\n"), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		server      *NatsServer
+		expected    *NatsServer
+		expectError bool
+	}{
+		{
+			name:   "no context",
+			server: &NatsServer{NatsUrl: "nats://local:4222"},
+			expected: &NatsServer{
+				NatsUrl: "nats://local:4222",
+			},
+		},
+		{
+			name:   "named context",
+			server: &NatsServer{Context: "prod"},
+			expected: &NatsServer{
+				NatsUrl:            "nats://prod:4222",
+				UserCredentialFile: "/path/to/prod.creds",
+				InboxPrefix:        "_INBOX_prod",
+				TLS:                &TLSConfig{CA: []string{"/path/to/ca.pem"}},
+			},
+		},
+		{
+			name:   "selected context",
+			server: &NatsServer{Context: ContextSelected},
+			expected: &NatsServer{
+				NatsUrl:            "nats://prod:4222",
+				UserCredentialFile: "/path/to/prod.creds",
+				InboxPrefix:        "_INBOX_prod",
+				TLS:                &TLSConfig{CA: []string{"/path/to/ca.pem"}},
+			},
+		},
+		{
+			name:   "explicit options override the context",
+			server: &NatsServer{Context: "prod", NatsUrl: "nats://other:4222", User: "user", Password: "secret"},
+			expected: &NatsServer{
+				NatsUrl:     "nats://other:4222",
+				User:        "user",
+				Password:    "secret",
+				InboxPrefix: "_INBOX_prod",
+				TLS:         &TLSConfig{CA: []string{"/path/to/ca.pem"}},
+			},
+		},
+		{
+			name:        "unknown context",
+			server:      &NatsServer{Context: "unknown"},
+			expectError: true,
+		},
+		{
+			name:        "invalid context name",
+			server:      &NatsServer{Context: "../prod"},
+			expectError: true,
+		},
+		{
+			name:        "unsupported nsc lookup",
+			server:      &NatsServer{Context: "nsc"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.server.applyContext()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+
+			// all connection options are part of the connection key.
+			got, _ := tt.server.connectionKey("default", tt.server.NatsUrl, "")
+			expected, _ := tt.expected.connectionKey("default", tt.expected.NatsUrl, "")
+			if got != expected {
+				t.Errorf("expected %s, got %s", expected, got)
+			}
+		})
+	}
+}
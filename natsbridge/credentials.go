@@ -0,0 +1,130 @@
+package natsbridge
+
+import (
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultCredentialWatchInterval is the default interval in which credential files are checked for rotation.
+const DefaultCredentialWatchInterval = 10 * time.Second
+
+// credentialWatcher polls the credential files of a pooled connection for rotation, f.e. by a secrets operator.
+// nats.go reads credential and token files on every (re)connect, so a rotated file is used on the next reconnect;
+// if a reconnect grace period is configured, the connection is reconnected proactively. Subscriptions are kept
+// across reconnects.
+//
+// NKey seed files are watched as well, but a connection keeps the NKey it was created with; a rotated NKey is used
+// by the new connection of the next config reload.
+type credentialWatcher struct {
+	pc *pooledConn
+	// hashes of the watched files, by path; the zero hash means the file could not be read.
+	hashes map[string][sha256.Size]byte
+	nkey   string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// credentialFiles returns all credential files of the server which are read on (re)connect.
+func (server *NatsServer) credentialFiles() []string {
+	var files []string
+	for _, file := range []string{server.UserCredentialFile, server.TokenFile, server.NkeyCredentialFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// watchCredentials starts watching the credential files of the pooled connection's server, if it has any.
+func (pc *pooledConn) watchCredentials(server *NatsServer) {
+	files := server.credentialFiles()
+	if len(files) == 0 {
+		return
+	}
+	w := &credentialWatcher{
+		pc:     pc,
+		hashes: make(map[string][sha256.Size]byte),
+		nkey:   server.NkeyCredentialFile,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, file := range files {
+		w.hashes[file], _ = hashFile(file)
+	}
+	pc.credentials = w
+	go w.run()
+}
+
+// stopWatchingCredentials stops the credential watcher, if any, and waits until it is stopped.
+func (pc *pooledConn) stopWatchingCredentials() {
+	if pc.credentials == nil {
+		return
+	}
+	close(pc.credentials.stop)
+	<-pc.credentials.done
+}
+
+func (w *credentialWatcher) run() {
+	defer close(w.done)
+	for {
+		owner := w.pc.owner()
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(owner.server.CredentialWatchInterval):
+		}
+
+		if !w.poll(owner.logger) || owner.server.CredentialReconnectGrace == nil {
+			continue
+		}
+
+		grace := *owner.server.CredentialReconnectGrace
+		owner.logger.Info("reconnecting to NATS to use the rotated credentials", zap.Duration("grace", grace))
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(grace):
+		}
+		if err := w.pc.conn.ForceReconnect(); err != nil {
+			owner.logger.Warn("could not reconnect to NATS after credential rotation", zap.Error(err))
+		}
+	}
+}
+
+// poll checks all watched files for changes, and returns true if a file was rotated which can be used by
+// reconnecting.
+func (w *credentialWatcher) poll(logger *zap.Logger) bool {
+	rotated := false
+	for file, oldHash := range w.hashes {
+		hash, err := hashFile(file)
+		if err != nil {
+			// f.e. while the file is replaced; we try again on the next poll.
+			logger.Warn("could not read credential file", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		if hash == oldHash {
+			continue
+		}
+		w.hashes[file] = hash
+
+		if file == w.nkey {
+			logger.Warn("NKey credential file changed; the connection keeps using the previous NKey until Caddy is reloaded",
+				zap.String("file", file))
+			continue
+		}
+		logger.Info("credential file rotated, using it on the next reconnect", zap.String("file", file))
+		rotated = true
+	}
+	return rotated
+}
+
+func hashFile(file string) ([sha256.Size]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
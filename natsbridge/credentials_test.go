@@ -0,0 +1,136 @@
+package natsbridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+)
+
+func TestCredentialWatcher(t *testing.T) {
+	e := &EmbeddedServer{}
+	if err := e.provision(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.start(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.stop(zap.NewNop())
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	nkeyFile := filepath.Join(dir, "user.nk")
+	for _, file := range []string{tokenFile, nkeyFile} {
+		if err := os.WriteFile(file, []byte("old"), 0o600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	grace := 10 * time.Millisecond
+	server := &NatsServer{
+		TokenFile:                tokenFile,
+		CredentialWatchInterval:  10 * time.Millisecond,
+		CredentialReconnectGrace: &grace,
+	}
+	conn, err := nats.Connect("", e.connectOption())
+	if err != nil {
+		t.Fatalf("could not connect to embedded server: %v", err)
+	}
+	defer conn.Close()
+	pc := &pooledConn{conn: conn}
+	pc.addOwner(&connOwner{server: server, logger: zap.NewNop()})
+
+	w := &credentialWatcher{pc: pc, hashes: map[string][32]byte{}, nkey: nkeyFile}
+	for _, file := range []string{tokenFile, nkeyFile} {
+		w.hashes[file], _ = hashFile(file)
+	}
+	if w.poll(zap.NewNop()) {
+		t.Errorf("expected unchanged files not to be reported as rotated")
+	}
+	if err := os.WriteFile(nkeyFile, []byte("new"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.poll(zap.NewNop()) {
+		t.Errorf("expected a changed NKey not to trigger a reconnect")
+	}
+
+	pc.watchCredentials(server)
+	if err := os.WriteFile(tokenFile, []byte("new"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.Stats().Reconnects == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pc.stopWatchingCredentials()
+	if conn.Stats().Reconnects == 0 {
+		t.Errorf("expected a reconnect after the token file was rotated")
+	}
+}
+
+func TestNkeyRotation(t *testing.T) {
+	var seeds [][]byte
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	for range 2 {
+		kp, err := nkeys.CreateUser()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		seed, _ := kp.Seed()
+		pub, _ := kp.PublicKey()
+		seeds = append(seeds, seed)
+		opts.Nkeys = append(opts.Nkeys, &server.NkeyUser{Nkey: pub})
+	}
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+
+	nkeyFile := filepath.Join(t.TempDir(), "user.nk")
+	if err := os.WriteFile(nkeyFile, seeds[0], 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &NatsServer{NatsUrl: srv.ClientURL(), NkeyCredentialFile: nkeyFile}
+	oldKey, err := s.connectionKey("default", s.NatsUrl, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	authOpts, err := s.authOptions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn, err := nats.Connect(s.NatsUrl, append(authOpts, nats.ReconnectWait(10*time.Millisecond))...)
+	if err != nil {
+		t.Fatalf("could not connect with NKey: %v", err)
+	}
+	defer conn.Close()
+
+	// the connection keeps signing with the NKey it was created with.
+	if err := os.WriteFile(nkeyFile, seeds[1], 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.ForceReconnect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.Stats().Reconnects == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn.Stats().Reconnects == 0 || !conn.IsConnected() {
+		t.Errorf("expected the connection to reconnect after the NKey file was rotated, last error: %v", conn.LastError())
+	}
+
+	// a reload creates a new connection with the rotated NKey.
+	newKey, err := s.connectionKey("default", s.NatsUrl, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newKey == oldKey {
+		t.Errorf("expected a new connection key after the NKey file was rotated")
+	}
+}
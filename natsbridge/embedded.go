@@ -0,0 +1,258 @@
+package natsbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// EmbeddedServer runs a NATS server inside the Caddy process; f.e. for edge deployments or local development
+// without an external NATS server. Server aliases without an url connect to the embedded server in-process.
+type EmbeddedServer struct {
+	// ServerName of the embedded NATS server. Defaults to "caddy".
+	ServerName string `json:"serverName,omitempty"`
+	// Listen is the host:port the embedded server accepts connections of external clients on. If not set, the
+	// embedded server does not listen at all, and only the server aliases of this Caddy instance can connect.
+	Listen string `json:"listen,omitempty"`
+	// JetStream enables JetStream on the embedded server and all its accounts.
+	JetStream bool `json:"jetstream,omitempty"`
+	// StoreDir is the directory JetStream stores its data in. Defaults to "nats/jetstream" in Caddy's data directory.
+	StoreDir string `json:"storeDir,omitempty"`
+	// LeafNodeRemotes connect the embedded server as leaf node to other NATS servers or clusters.
+	LeafNodeRemotes []*LeafNodeRemote `json:"leafNodeRemotes,omitempty"`
+	// Accounts of the embedded server. If empty, all clients share the global account and no authentication is
+	// required.
+	Accounts []*EmbeddedAccount `json:"accounts,omitempty"`
+	// ReadyTimeout is the time to wait for the embedded server to accept connections. Defaults to 10s.
+	ReadyTimeout time.Duration `json:"readyTimeout,omitempty"`
+
+	srv *server.Server
+}
+
+type LeafNodeRemote struct {
+	// can also contain comma-separated list of URLs
+	URL string `json:"url,omitempty"`
+	// Account is the local account the leaf node connection is bound to; defaults to the global account.
+	Account     string `json:"account,omitempty"`
+	Credentials string `json:"credentials,omitempty"`
+}
+
+type EmbeddedAccount struct {
+	Name  string          `json:"name,omitempty"`
+	Users []*EmbeddedUser `json:"users,omitempty"`
+}
+
+type EmbeddedUser struct {
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// embeddedPool keeps the embedded NATS server running across config reloads, as long as its configuration did
+// not change; see connPool.
+var embeddedPool = caddy.NewUsagePool()
+
+type pooledEmbeddedServer struct {
+	srv *server.Server
+}
+
+func (p *pooledEmbeddedServer) Destruct() error {
+	p.srv.Shutdown()
+	p.srv.WaitForShutdown()
+	return nil
+}
+
+// provision validates the embedded server configuration and fills in the defaults.
+func (e *EmbeddedServer) provision() error {
+	if e.ServerName == "" {
+		e.ServerName = "caddy"
+	}
+	if e.JetStream && e.StoreDir == "" {
+		e.StoreDir = filepath.Join(caddy.AppDataDir(), "nats", "jetstream")
+	}
+	if e.ReadyTimeout == 0 {
+		e.ReadyTimeout = 10 * time.Second
+	}
+
+	if _, err := e.serverOptions(); err != nil {
+		return fmt.Errorf("embedded: %w", err)
+	}
+	return nil
+}
+
+// serverOptions converts the configuration to the options of nats-server.
+func (e *EmbeddedServer) serverOptions() (*server.Options, error) {
+	opts := &server.Options{
+		ServerName: e.ServerName,
+		JetStream:  e.JetStream,
+		StoreDir:   e.StoreDir,
+		// signals are handled by Caddy.
+		NoSigs: true,
+		// the server aliases connect in-process.
+		DontListen: e.Listen == "",
+	}
+	if e.Listen != "" {
+		host, portStr, err := net.SplitHostPort(e.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %s: %w", e.Listen, err)
+		}
+		opts.Host = host
+		opts.Port, err = strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen port %s: %w", portStr, err)
+		}
+	}
+
+	accounts := make(map[string]*server.Account)
+	for _, a := range e.Accounts {
+		if a.Name == "" {
+			return nil, fmt.Errorf("account name must not be empty")
+		}
+		if _, ok := accounts[a.Name]; ok {
+			return nil, fmt.Errorf("account %s defined twice", a.Name)
+		}
+		acc := server.NewAccount(a.Name)
+		accounts[a.Name] = acc
+		opts.Accounts = append(opts.Accounts, acc)
+		for _, u := range a.Users {
+			if u.User == "" {
+				return nil, fmt.Errorf("account %s: user must not be empty", a.Name)
+			}
+			opts.Users = append(opts.Users, &server.User{Username: u.User, Password: u.Password, Account: acc})
+		}
+	}
+
+	for _, r := range e.LeafNodeRemotes {
+		if r.Account != "" {
+			if _, ok := accounts[r.Account]; !ok {
+				return nil, fmt.Errorf("leaf node remote %s: unknown account %s", r.URL, r.Account)
+			}
+		}
+		if r.URL == "" {
+			return nil, fmt.Errorf("leaf node remote without url")
+		}
+		remote := &server.RemoteLeafOpts{LocalAccount: r.Account, Credentials: r.Credentials}
+		for _, u := range strings.Split(r.URL, ",") {
+			parsed, err := url.Parse(strings.TrimSpace(u))
+			if err != nil {
+				return nil, fmt.Errorf("invalid leaf node remote url %s: %w", u, err)
+			}
+			remote.URLs = append(remote.URLs, parsed)
+		}
+		opts.LeafNode.Remotes = append(opts.LeafNode.Remotes, remote)
+	}
+
+	return opts, nil
+}
+
+// key identifies a pooled embedded server by its full configuration.
+func (e *EmbeddedServer) key() (string, error) {
+	key, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("could not build embedded server key: %w", err)
+	}
+	return string(key), nil
+}
+
+// start starts the embedded server (or re-uses the one of a previous config) and waits until it accepts connections.
+func (e *EmbeddedServer) start(logger *zap.Logger) error {
+	key, err := e.key()
+	if err != nil {
+		return err
+	}
+
+	val, loaded, err := embeddedPool.LoadOrNew(key, func() (caddy.Destructor, error) {
+		opts, err := e.serverOptions()
+		if err != nil {
+			return nil, err
+		}
+		srv, err := server.NewServer(opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not create embedded NATS server: %w", err)
+		}
+		srv.SetLoggerV2(&serverLogger{logger: logger.Sugar()}, false, false, false)
+
+		logger.Info("starting embedded NATS server", zap.String("listen", e.Listen), zap.Bool("jetstream", e.JetStream))
+		go srv.Start()
+		if !srv.ReadyForConnections(e.ReadyTimeout) {
+			srv.Shutdown()
+			return nil, fmt.Errorf("embedded NATS server not ready after %s", e.ReadyTimeout)
+		}
+
+		if e.JetStream {
+			for _, a := range e.Accounts {
+				acc, err := srv.LookupAccount(a.Name)
+				if err == nil {
+					err = acc.EnableJetStream(nil)
+				}
+				if err != nil {
+					srv.Shutdown()
+					return nil, fmt.Errorf("could not enable JetStream for account %s: %w", a.Name, err)
+				}
+			}
+		}
+
+		return &pooledEmbeddedServer{srv: srv}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.srv = val.(*pooledEmbeddedServer).srv
+	if loaded {
+		logger.Info("re-using embedded NATS server of previous config", zap.String("id", e.srv.ID()))
+	} else {
+		logger.Info("embedded NATS server started", zap.String("id", e.srv.ID()))
+	}
+	return nil
+}
+
+// stop releases the embedded server; it is shut down if no other config uses it anymore.
+func (e *EmbeddedServer) stop(logger *zap.Logger) error {
+	if e.srv == nil {
+		return nil
+	}
+
+	key, err := e.key()
+	if err != nil {
+		return err
+	}
+	e.srv = nil
+	deleted, err := embeddedPool.Delete(key)
+	if deleted {
+		logger.Info("embedded NATS server stopped")
+	}
+	return err
+}
+
+// connectOption connects a server alias in-process to the embedded server, without a network connection.
+func (e *EmbeddedServer) connectOption() nats.Option {
+	return nats.InProcessServer(e.srv)
+}
+
+// serverLogger forwards the log output of the embedded NATS server to Caddy's logger.
+type serverLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func (l *serverLogger) Noticef(format string, v ...interface{}) { l.logger.Infof(format, v...) }
+func (l *serverLogger) Warnf(format string, v ...interface{})   { l.logger.Warnf(format, v...) }
+func (l *serverLogger) Fatalf(format string, v ...interface{})  { l.logger.Errorf(format, v...) }
+func (l *serverLogger) Errorf(format string, v ...interface{})  { l.logger.Errorf(format, v...) }
+func (l *serverLogger) Debugf(format string, v ...interface{})  { l.logger.Debugf(format, v...) }
+func (l *serverLogger) Tracef(format string, v ...interface{})  { l.logger.Debugf(format, v...) }
+
+// Interface guards
+var (
+	_ server.Logger    = (*serverLogger)(nil)
+	_ caddy.Destructor = (*pooledEmbeddedServer)(nil)
+)
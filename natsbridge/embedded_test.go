@@ -0,0 +1,104 @@
+package natsbridge
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	e := &EmbeddedServer{
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		Accounts: []*EmbeddedAccount{
+			{Name: "app", Users: []*EmbeddedUser{{User: "user", Password: "secret"}}},
+		},
+	}
+	if err := e.provision(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.start(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e.srv.Addr() != nil {
+		t.Errorf("expected the embedded server not to listen without listen address, but got %s", e.srv.Addr())
+	}
+
+	nc, err := nats.Connect("", e.connectOption(), nats.UserInfo("user", "secret"))
+	if err != nil {
+		t.Fatalf("could not connect to embedded server: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := js.AccountInfo(); err != nil {
+		t.Errorf("expected JetStream to be enabled for the account, but got: %v", err)
+	}
+
+	connectOption := e.connectOption()
+	if err := e.stop(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := nats.Connect("", connectOption, nats.UserInfo("user", "secret")); err == nil {
+		t.Errorf("expected the embedded server to be stopped")
+	}
+}
+
+func TestEmbeddedServerListen(t *testing.T) {
+	e := &EmbeddedServer{
+		Listen:   "127.0.0.1:-1",
+		Accounts: []*EmbeddedAccount{{Name: "app", Users: []*EmbeddedUser{{User: "user", Password: "secret"}}}},
+	}
+	if err := e.provision(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.start(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.stop(zap.NewNop())
+
+	nc, err := nats.Connect(e.srv.ClientURL(), nats.UserInfo("user", "secret"))
+	if err != nil {
+		t.Fatalf("expected external clients to connect to the listen address, but got: %v", err)
+	}
+	nc.Close()
+
+	if _, err := nats.Connect(e.srv.ClientURL(), nats.UserInfo("user", "wrong")); err == nil {
+		t.Errorf("expected an authorization error, but got none")
+	}
+}
+
+func TestEmbeddedServerInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		embedded *EmbeddedServer
+	}{
+		{
+			name:     "invalid listen address",
+			embedded: &EmbeddedServer{Listen: "localhost"},
+		},
+		{
+			name:     "account without name",
+			embedded: &EmbeddedServer{Accounts: []*EmbeddedAccount{{}}},
+		},
+		{
+			name: "leaf node remote with unknown account",
+			embedded: &EmbeddedServer{
+				LeafNodeRemotes: []*LeafNodeRemote{{URL: "nats-leaf://hub:7422", Account: "unknown"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.embedded.provision(); err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package natsbridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// JetStreamConfig configures how the JetStream API of a NATS server is reached, f.e. through a leafnode or another
+// account.
+type JetStreamConfig struct {
+	// Domain is the JetStream domain, f.e. of the hub when connected to a leafnode.
+	Domain string `json:"domain,omitempty"`
+	// APIPrefix is the subject prefix of the JetStream API, f.e. when it is imported from another account.
+	// Mutually exclusive with Domain.
+	APIPrefix string `json:"apiPrefix,omitempty"`
+	// DefaultTimeout is the timeout of JetStream API requests. Defaults to 5s.
+	DefaultTimeout time.Duration `json:"defaultTimeout,omitempty"`
+}
+
+func (c *JetStreamConfig) provision() error {
+	if c.Domain != "" && c.APIPrefix != "" {
+		return fmt.Errorf("jetstream: domain and api_prefix are mutually exclusive")
+	}
+	return nil
+}
+
+func (c *JetStreamConfig) options() []nats.JSOpt {
+	var opts []nats.JSOpt
+	if c == nil {
+		return opts
+	}
+	if c.Domain != "" {
+		opts = append(opts, nats.Domain(c.Domain))
+	}
+	if c.APIPrefix != "" {
+		opts = append(opts, nats.APIPrefix(c.APIPrefix))
+	}
+	if c.DefaultTimeout > 0 {
+		opts = append(opts, nats.MaxWait(c.DefaultTimeout))
+	}
+	return opts
+}
+
+// jetStreamCache holds the JetStream context of a server, which is created once per connection.
+type jetStreamCache struct {
+	mu   sync.Mutex
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+// JetStream returns the JetStream context of the server, configured with its jetstream options. All parts of the
+// bridge which use JetStream must obtain their context here.
+func (server *NatsServer) JetStream() (nats.JetStreamContext, error) {
+	server.jetStream.mu.Lock()
+	defer server.jetStream.mu.Unlock()
+
+	if server.Conn == nil {
+		return nil, fmt.Errorf("could not load JetStream: not connected to NATS yet")
+	}
+	if server.jetStream.js != nil && server.jetStream.conn == server.Conn {
+		return server.jetStream.js, nil
+	}
+
+	js, err := server.Conn.JetStream(server.JetStreamConfig.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load JetStream: %w", err)
+	}
+	server.jetStream.conn = server.Conn
+	server.jetStream.js = js
+	return js, nil
+}
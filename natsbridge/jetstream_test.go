@@ -0,0 +1,73 @@
+package natsbridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestJetStreamConfigValidation(t *testing.T) {
+	c := &JetStreamConfig{Domain: "hub", APIPrefix: "$JS.hub.API"}
+	if err := c.provision(); err == nil {
+		t.Errorf("expected an error, but got none")
+	}
+}
+
+func TestJetStream(t *testing.T) {
+	e := &EmbeddedServer{JetStream: true, StoreDir: t.TempDir()}
+	if err := e.provision(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.start(zap.NewNop()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer e.stop(zap.NewNop())
+
+	nc, err := nats.Connect("", e.connectOption())
+	if err != nil {
+		t.Fatalf("could not connect to embedded server: %v", err)
+	}
+	defer nc.Close()
+
+	tests := []struct {
+		name        string
+		config      *JetStreamConfig
+		expectError bool
+	}{
+		{
+			name: "no config",
+		},
+		{
+			name:   "matching api prefix",
+			config: &JetStreamConfig{APIPrefix: "$JS.API"},
+		},
+		{
+			name:        "unknown domain",
+			config:      &JetStreamConfig{Domain: "unknown", DefaultTimeout: 100 * time.Millisecond},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &NatsServer{JetStreamConfig: tt.config, Conn: nc}
+			js, err := server.JetStream()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if again, _ := server.JetStream(); again != js {
+				t.Errorf("expected the JetStream context to be re-used")
+			}
+
+			_, err = js.AccountInfo()
+			if tt.expectError && err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
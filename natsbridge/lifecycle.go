@@ -0,0 +1,81 @@
+package natsbridge
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// reconnectOptions converts the reconnect and ping tuning of the server to options for nats.Connect.
+func (server *NatsServer) reconnectOptions() []nats.Option {
+	opts := []nats.Option{nats.MaxReconnects(-1)}
+
+	if server.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(server.ReconnectWait))
+	}
+	if server.ReconnectJitter > 0 {
+		opts = append(opts, nats.ReconnectJitter(server.ReconnectJitter, server.ReconnectJitter))
+	}
+	if server.ReconnectBufferSize != 0 {
+		opts = append(opts, nats.ReconnectBufSize(server.ReconnectBufferSize))
+	}
+	if server.PingInterval > 0 {
+		opts = append(opts, nats.PingInterval(server.PingInterval))
+	}
+	if server.MaxPingsOutstanding > 0 {
+		opts = append(opts, nats.MaxPingsOutstanding(server.MaxPingsOutstanding))
+	}
+
+	return opts
+}
+
+// lifecycleOptions registers handlers for all connection state transitions and asynchronous errors, and logs them
+// with the logger of the server which currently owns the pooled connection.
+func (pc *pooledConn) lifecycleOptions() []nats.Option {
+	return []nats.Option{
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			logger := pc.owner().logger
+			if err != nil {
+				logger.Warn("disconnected from NATS server", zap.String("url", conn.ConnectedUrlRedacted()), zap.Error(err))
+				return
+			}
+			logger.Info("disconnected from NATS server", zap.String("url", conn.ConnectedUrlRedacted()))
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			owner := pc.owner()
+			now := time.Now()
+			pc.lastReconnect.Store(&now)
+			owner.app.metrics.Reconnects.WithLabelValues(owner.alias).Inc()
+			owner.logger.Info("reconnected to NATS server",
+				zap.String("url", conn.ConnectedUrlRedacted()),
+				zap.Uint64("reconnects", conn.Stats().Reconnects))
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			defer close(pc.closed)
+			logger := pc.owner().logger
+			if err := conn.LastError(); err != nil {
+				logger.Warn("NATS connection closed", zap.Error(err))
+				return
+			}
+			logger.Info("NATS connection closed")
+		}),
+		nats.DiscoveredServersHandler(func(conn *nats.Conn) {
+			pc.owner().logger.Info("discovered new NATS servers", zap.Strings("servers", conn.DiscoveredServers()))
+		}),
+		nats.LameDuckModeHandler(func(conn *nats.Conn) {
+			pc.owner().logger.Warn("NATS server entered lame duck mode, will reconnect to another server",
+				zap.String("url", conn.ConnectedUrlRedacted()))
+		}),
+		nats.ErrorHandler(func(conn *nats.Conn, sub *nats.Subscription, err error) {
+			fields := []zap.Field{zap.Error(err)}
+			if sub != nil {
+				fields = append(fields, zap.String("subject", sub.Subject), zap.String("queue_group", sub.Queue))
+				if pendingMsgs, pendingBytes, pendingErr := sub.Pending(); pendingErr == nil {
+					fields = append(fields, zap.Int("pending_msgs", pendingMsgs), zap.Int("pending_bytes", pendingBytes))
+				}
+			}
+			pc.owner().logger.Error("asynchronous NATS error", fields...)
+		}),
+	}
+}
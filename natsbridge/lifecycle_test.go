@@ -0,0 +1,117 @@
+package natsbridge
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReconnectOptions(t *testing.T) {
+	server := &NatsServer{
+		ReconnectWait:       time.Second,
+		ReconnectJitter:     100 * time.Millisecond,
+		ReconnectBufferSize: -1,
+		PingInterval:        time.Minute,
+		MaxPingsOutstanding: 5,
+	}
+	opts := nats.GetDefaultOptions()
+	for _, opt := range server.reconnectOptions() {
+		if err := opt(&opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if opts.MaxReconnect != -1 {
+		t.Errorf("expected unlimited reconnects, got %d", opts.MaxReconnect)
+	}
+	if opts.ReconnectWait != time.Second || opts.ReconnectJitter != 100*time.Millisecond || opts.ReconnectJitterTLS != 100*time.Millisecond {
+		t.Errorf("unexpected reconnect wait %s and jitter %s/%s", opts.ReconnectWait, opts.ReconnectJitter, opts.ReconnectJitterTLS)
+	}
+	if opts.ReconnectBufSize != -1 {
+		t.Errorf("expected reconnect buffer size -1, got %d", opts.ReconnectBufSize)
+	}
+	if opts.PingInterval != time.Minute || opts.MaxPingsOut != 5 {
+		t.Errorf("unexpected ping interval %s and max pings outstanding %d", opts.PingInterval, opts.MaxPingsOut)
+	}
+
+	// unset values keep the nats.go defaults.
+	defaults := nats.GetDefaultOptions()
+	for _, opt := range (&NatsServer{}).reconnectOptions() {
+		if err := opt(&defaults); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if defaults.ReconnectWait != nats.DefaultReconnectWait || defaults.PingInterval != nats.DefaultPingInterval {
+		t.Errorf("expected the nats.go defaults, got reconnect wait %s and ping interval %s", defaults.ReconnectWait, defaults.PingInterval)
+	}
+}
+
+func TestLifecycleCallbacks(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	core, logs := observer.New(zap.InfoLevel)
+	s := &NatsServer{NatsUrl: srv.ClientURL(), ReconnectWait: 10 * time.Millisecond}
+	pc := &pooledConn{closed: make(chan struct{})}
+	pc.addOwner(&connOwner{app: &NatsBridgeApp{metrics: metrics}, alias: "default", server: s, logger: zap.New(core)})
+
+	pc.conn, err = nats.Connect(s.NatsUrl, append(s.reconnectOptions(), pc.lifecycleOptions()...)...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.pooled = pc
+	if !s.LastReconnect().IsZero() {
+		t.Errorf("expected no reconnect yet")
+	}
+
+	// restart the server on the same port.
+	opts.Port = srv.Addr().(*net.TCPAddr).Port
+	srv.Shutdown()
+	srv = natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.LastReconnect().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.LastReconnect().IsZero() {
+		t.Fatalf("expected the reconnect to be recorded")
+	}
+	reconnects := &dto.Metric{}
+	if err := metrics.Reconnects.WithLabelValues("default").Write(reconnects); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reconnects.GetCounter().GetValue() != 1 {
+		t.Errorf("expected 1 reconnect, got %v", reconnects.GetCounter().GetValue())
+	}
+
+	pc.conn.Close()
+	select {
+	case <-pc.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the closed handler to be called")
+	}
+
+	for _, msg := range []string{"disconnected from NATS server", "reconnected to NATS server", "NATS connection closed"} {
+		if logs.FilterMessage(msg).Len() == 0 {
+			t.Errorf("expected log message %q", msg)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package natsbridge
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/CoverWhale/caddy-nats-bridge/common"
@@ -19,21 +23,64 @@ import (
 type NatsBridgeApp struct {
 	// Immutable after provisioning
 	Servers map[string]*NatsServer `json:"servers,omitempty"`
+	// DrainTimeout is the time Stop waits for in-flight messages and requests to complete before the connections
+	// are closed. Defaults to 30s.
+	DrainTimeout time.Duration `json:"drainTimeout,omitempty"`
+	// Embedded runs a NATS server inside the Caddy process, which is started before the servers connect.
+	Embedded *EmbeddedServer `json:"embedded,omitempty"`
 
-	logger *zap.Logger
-	ctx    caddy.Context
+	logger  *zap.Logger
+	ctx     caddy.Context
+	metrics *common.Metrics
 }
 
 type NatsServer struct {
+	// Context is the name of a NATS CLI context (see "nats context") to load the connection options from; or
+	// "@selected" for the currently selected context. Explicitly configured options take precedence.
+	Context string `json:"context,omitempty"`
 	// can also contain comma-separated list of URLs, see nats.Connect
-	NatsUrl            string         `json:"url,omitempty"`
-	UserCredentialFile string         `json:"userCredentialFile,omitempty"`
-	NkeyCredentialFile string         `json:"nkeyCredentialFile,omitempty"`
-	JWT                string         `json:"jwt,omitempty"`
-	Seed               string         `json:"seed,omitempty"`
-	ClientName         string         `json:"clientName,omitempty"`
-	InboxPrefix        string         `json:"inboxPrefix,omitempty"`
-	DefaultTimeout     *time.Duration `json:"defaultTimeout,omitempty"`
+	NatsUrl            string     `json:"url,omitempty"`
+	UserCredentialFile string     `json:"userCredentialFile,omitempty"`
+	NkeyCredentialFile string     `json:"nkeyCredentialFile,omitempty"`
+	JWT                string     `json:"jwt,omitempty"`
+	Seed               string     `json:"seed,omitempty"`
+	User               string     `json:"user,omitempty"`
+	Password           string     `json:"password,omitempty"`
+	Token              string     `json:"token,omitempty"`
+	TokenFile          string     `json:"tokenFile,omitempty"`
+	ClientName         string     `json:"clientName,omitempty"`
+	InboxPrefix        string     `json:"inboxPrefix,omitempty"`
+	TLS                *TLSConfig `json:"tls,omitempty"`
+	// JetStreamConfig configures the JetStream context used by all JetStream features of this server.
+	JetStreamConfig *JetStreamConfig `json:"jetstream,omitempty"`
+	// DefaultTimeout is the timeout of nats_request handlers bound to this server which do not specify one.
+	DefaultTimeout *time.Duration `json:"defaultTimeout,omitempty"`
+	// SubjectPrefix is prepended to the subjects of all handlers and log outputs bound to this server, unless they
+	// override it. It must end with a dot.
+	SubjectPrefix string `json:"subjectPrefix,omitempty"`
+	// Headers are added to all messages of nats_publish, nats_request and log outputs, and to the HTTP requests of
+	// subscribe handlers bound to this server. Handlers can override single headers.
+	Headers map[string]string `json:"headers,omitempty"`
+	// ConnectMode is either "sync" (default) or "async". In async mode, Caddy starts even if NATS is unreachable;
+	// subscriptions are created as soon as the connection is established.
+	ConnectMode string `json:"connectMode,omitempty"`
+	// DisconnectedStatus is the HTTP status code nats_publish and nats_request respond with when the connection
+	// is not established in async connect mode. Defaults to 503.
+	DisconnectedStatus int `json:"disconnectedStatus,omitempty"`
+
+	// reconnect and ping tuning; see the respective options of nats.Connect. Unset values use the nats.go defaults.
+	ReconnectWait       time.Duration `json:"reconnectWait,omitempty"`
+	ReconnectJitter     time.Duration `json:"reconnectJitter,omitempty"`
+	ReconnectBufferSize int           `json:"reconnectBufferSize,omitempty"`
+	PingInterval        time.Duration `json:"pingInterval,omitempty"`
+	MaxPingsOutstanding int           `json:"maxPingsOutstanding,omitempty"`
+
+	// CredentialWatchInterval is the interval in which the credential and token files are checked for rotation.
+	// Rotated files are used on the next reconnect. Defaults to 10s.
+	CredentialWatchInterval time.Duration `json:"credentialWatchInterval,omitempty"`
+	// CredentialReconnectGrace enables proactive reconnects after a credential file was rotated; the connection
+	// is reconnected after the grace period.
+	CredentialReconnectGrace *time.Duration `json:"credentialReconnectGrace,omitempty"`
 
 	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=nats.handlers inline_key=handler"`
 
@@ -41,8 +88,19 @@ type NatsServer struct {
 	Handlers []common.NatsHandler `json:"-"`
 
 	Conn *nats.Conn `json:"-"`
+
+	pooled        *pooledConn
+	poolKey       string
+	subscribeOnce sync.Once
+	inFlight      common.InFlight
+	jetStream     jetStreamCache
 }
 
+const (
+	ConnectModeSync  = "sync"
+	ConnectModeAsync = "async"
+)
+
 // CaddyModule returns the Caddy module information.
 func (app NatsBridgeApp) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -61,8 +119,53 @@ func (app *NatsBridgeApp) Provision(ctx caddy.Context) error {
 	app.ctx = ctx
 	app.logger = ctx.Logger(app)
 
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+	app.metrics = metrics
+
+	if app.DrainTimeout == 0 {
+		app.DrainTimeout = nats.DefaultDrainTimeout
+	}
+	if app.Embedded != nil {
+		if err := app.Embedded.provision(); err != nil {
+			return err
+		}
+	}
+
 	// Set up handlers for each server
-	for _, server := range app.Servers {
+	for alias, server := range app.Servers {
+		if err := server.applyContext(); err != nil {
+			return fmt.Errorf("server %s: %w", alias, err)
+		}
+		if err := server.validateAuth(); err != nil {
+			return fmt.Errorf("server %s: %w", alias, err)
+		}
+		switch server.ConnectMode {
+		case "", ConnectModeSync, ConnectModeAsync:
+		default:
+			return fmt.Errorf("server %s: unknown connectMode %s, must be one of: %s, %s", alias, server.ConnectMode, ConnectModeSync, ConnectModeAsync)
+		}
+		if server.DisconnectedStatus == 0 {
+			server.DisconnectedStatus = http.StatusServiceUnavailable
+		}
+		if err := common.ValidateSubjectPrefix(server.SubjectPrefix); err != nil {
+			return fmt.Errorf("server %s: %w", alias, err)
+		}
+		if server.CredentialWatchInterval <= 0 {
+			server.CredentialWatchInterval = DefaultCredentialWatchInterval
+		}
+		if server.TLS != nil {
+			if err := server.TLS.provision(ctx); err != nil {
+				return fmt.Errorf("server %s: %w", alias, err)
+			}
+		}
+		if server.JetStreamConfig != nil {
+			if err := server.JetStreamConfig.provision(); err != nil {
+				return fmt.Errorf("server %s: %w", alias, err)
+			}
+		}
 		if server.HandlersRaw != nil {
 			vals, err := ctx.LoadModule(server, "HandlersRaw")
 			if err != nil {
@@ -74,81 +177,109 @@ func (app *NatsBridgeApp) Provision(ctx caddy.Context) error {
 		}
 	}
 
-	return nil
+	return app.validateRequiredAliases(ctx)
 }
 
 func (app *NatsBridgeApp) Start() error {
-	for _, server := range app.Servers {
-		// Connect to the NATS server
-		app.logger.Info("connecting via NATS URL: ", zap.String("natsUrl", server.NatsUrl))
-
-		var err error
-		var opts []nats.Option
-
-		if server.JWT != "" && server.Seed != "" {
-			opts = append(opts, nats.UserJWTAndSeed(server.JWT, server.Seed))
+	if app.Embedded != nil {
+		if err := app.Embedded.start(app.logger.Named("embedded")); err != nil {
+			return err
 		}
+	}
 
-		if server.ClientName != "" {
-			opts = append(opts, nats.Name(server.ClientName))
-		}
-		if server.InboxPrefix != "" {
-			opts = append(opts, nats.CustomInboxPrefix(server.InboxPrefix))
+	for alias, server := range app.Servers {
+		err := app.connect(alias, server)
+		if err != nil {
+			// release the connections and subscriptions which were already set up, so they are not leaked in the pools.
+			_ = app.Stop()
+			return err
 		}
+	}
+
+	return nil
+}
+
+// Stop drains all subscriptions and connections. It waits up to DrainTimeout for in-flight messages and requests
+// to be completed, and logs what was abandoned afterwards.
+func (app *NatsBridgeApp) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.DrainTimeout)
+	defer cancel()
 
-		if server.UserCredentialFile != "" {
-			// JWT
-			opts = append(opts, nats.UserCredentials(server.UserCredentialFile))
-		} else if server.NkeyCredentialFile != "" {
-			// NKEY
-			opt, err := nats.NkeyOptionFromSeed(server.NkeyCredentialFile)
+	// unsubscribe all handlers of all servers even if one fails, so no pooled subscription is leaked.
+	app.logger.Info("stopping all NATS subscriptions", zap.Duration("drainTimeout", app.DrainTimeout))
+	var errs []error
+	for alias, server := range app.Servers {
+		for _, handler := range server.Handlers {
+			err := handler.Unsubscribe(ctx, server.Conn)
 			if err != nil {
-				return fmt.Errorf("could not load NKey from %s: %w", server.NkeyCredentialFile, err)
+				errs = append(errs, fmt.Errorf("server %s: %w", alias, err))
 			}
-			opts = append(opts, opt)
 		}
+	}
 
-		opts = append(opts, nats.MaxReconnects(-1))
-
-		server.Conn, err = nats.Connect(server.NatsUrl, opts...)
+	for alias, server := range app.Servers {
+		logger := app.logger.With(zap.String("serverAlias", alias))
+		if abandoned := server.inFlight.Wait(ctx); abandoned > 0 {
+			logger.Warn("abandoning in-flight NATS requests", zap.Int("in_flight", abandoned))
+		}
+		err := server.disconnect(logger)
 		if err != nil {
-			return fmt.Errorf("could not connect to %s : %w", server.NatsUrl, err)
+			errs = append(errs, fmt.Errorf("server %s: closing NATS connection: %w", alias, err))
 		}
+	}
 
-		app.logger.Info("connected to NATS server", zap.String("url", server.Conn.ConnectedUrlRedacted()))
-
-		for _, handler := range server.Handlers {
-			err := handler.Subscribe(server.Conn)
-			if err != nil {
-				return err
-			}
+	// the embedded server is stopped last, so the connections to it can be closed cleanly.
+	if app.Embedded != nil {
+		if err := app.Embedded.stop(app.logger.Named("embedded")); err != nil {
+			errs = append(errs, fmt.Errorf("stopping embedded NATS server: %w", err))
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func (app *NatsBridgeApp) Stop() error {
-	defer func() {
-		for _, server := range app.Servers {
-			app.logger.Info("closing NATS connection", zap.String("url", server.Conn.ConnectedUrlRedacted()))
-			server.Conn.Close()
-		}
-	}()
-
-	app.logger.Info("stopping all NATS subscriptions")
-	for _, server := range app.Servers {
-		for _, handler := range server.Handlers {
-			err := handler.Unsubscribe(server.Conn)
-			if err != nil {
-				return err
-			}
-		}
+// Defaults returns the defaults which are inherited by all handlers bound to this server.
+func (server *NatsServer) Defaults() common.ServerDefaults {
+	return common.ServerDefaults{
+		SubjectPrefix: server.SubjectPrefix,
+		Headers:       server.Headers,
+		JetStream:     server.JetStream,
 	}
+}
+
+// TrackRequest marks the start of a request/reply call on the server, so Stop can wait for it to complete.
+// The returned function must be called once the call is completed.
+func (server *NatsServer) TrackRequest() func() {
+	server.inFlight.Begin()
+	return server.inFlight.End
+}
 
+// embeddedServer returns the embedded NATS server the server connects to in-process; nil if the server connects to
+// its url.
+func (app *NatsBridgeApp) embeddedServer(server *NatsServer) *EmbeddedServer {
+	if server.NatsUrl == "" && app.Embedded != nil {
+		return app.Embedded
+	}
 	return nil
 }
 
+// LastReconnect returns the time of the last successful reconnect, or the zero time if there was none.
+func (server *NatsServer) LastReconnect() time.Time {
+	if server.pooled == nil {
+		return time.Time{}
+	}
+	if t := server.pooled.lastReconnect.Load(); t != nil {
+		return *t
+	}
+	return time.Time{}
+}
+
+// Unavailable returns true if HTTP handlers should fail fast, because the server is configured with async connect
+// mode and is currently not connected.
+func (server *NatsServer) Unavailable() bool {
+	return server.ConnectMode == ConnectModeAsync && !server.Conn.IsConnected()
+}
+
 // Interface guards
 var (
 	_ caddy.App             = (*NatsBridgeApp)(nil)
@@ -0,0 +1,85 @@
+package natsbridge
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+	"github.com/nats-io/nats.go"
+)
+
+// TLSConfig configures the TLS client side of a NATS connection; f.e. for talking to a NATS cluster which
+// enforces mTLS.
+type TLSConfig struct {
+	// PEM files with the CAs which are used to verify the NATS server certificate. If empty, the system roots are used.
+	CA []string `json:"ca,omitempty"`
+	// PEM files of the client certificate and its key, presented to the NATS server.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// ClientCertificate is a subject name of a certificate managed by Caddy's own "tls" app. The certificate is
+	// looked up on every TLS handshake, so renewed certificates are picked up on the next reconnect.
+	// Mutually exclusive with Cert/Key.
+	ClientCertificate  string `json:"clientCertificate,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// HandshakeFirst performs the TLS handshake before the NATS INFO protocol is received. The NATS server must
+	// be configured with "handshake_first" as well.
+	HandshakeFirst bool `json:"handshakeFirst,omitempty"`
+}
+
+// provision validates the TLS configuration and makes sure Caddy's tls app is loaded if its certificates
+// should be used.
+func (t *TLSConfig) provision(ctx caddy.Context) error {
+	if (t.Cert == "") != (t.Key == "") {
+		return fmt.Errorf("tls: cert and key must be specified together")
+	}
+	if t.Cert != "" && t.ClientCertificate != "" {
+		return fmt.Errorf("tls: cert/key and client_certificate cannot be used together")
+	}
+	if t.ClientCertificate != "" {
+		if _, err := ctx.App("tls"); err != nil {
+			return fmt.Errorf("tls: getting tls app for client_certificate %s: %v", t.ClientCertificate, err)
+		}
+	}
+
+	return nil
+}
+
+// natsOptions converts the TLS configuration to options for nats.Connect.
+func (t *TLSConfig) natsOptions() []nats.Option {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.ClientCertificate != "" {
+		tlsConfig.GetClientCertificate = t.getCaddyClientCertificate
+	}
+
+	// NOTE: nats.Secure needs to come first, because the other options only set up a TLS config if none exists yet.
+	opts := []nats.Option{nats.Secure(tlsConfig)}
+	if len(t.CA) > 0 {
+		opts = append(opts, nats.RootCAs(t.CA...))
+	}
+	if t.Cert != "" {
+		opts = append(opts, nats.ClientCert(t.Cert, t.Key))
+	}
+	if t.HandshakeFirst {
+		opts = append(opts, nats.TLSHandshakeFirst())
+	}
+
+	return opts
+}
+
+// getCaddyClientCertificate returns a currently valid certificate for ClientCertificate from Caddy's certificate cache.
+func (t *TLSConfig) getCaddyClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	for _, cert := range caddytls.AllMatchingCertificates(t.ClientCertificate) {
+		if cert.Expired() {
+			continue
+		}
+		return &cert.Certificate, nil
+	}
+
+	return nil, fmt.Errorf("no valid certificate for %s found in Caddy's tls app", t.ClientCertificate)
+}
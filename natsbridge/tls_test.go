@@ -0,0 +1,148 @@
+package natsbridge
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// writeCertificate writes a self-signed certificate and its key as PEM files, and returns their paths.
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nats.example.com"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+
+	tests := []struct {
+		name                 string
+		tls                  TLSConfig
+		expectProvisionError bool
+		expectOptionsError   bool
+	}{
+		{
+			name: "server verification only",
+			tls:  TLSConfig{ServerName: "nats.example.com", HandshakeFirst: true},
+		},
+		{
+			name: "ca, cert and key",
+			tls:  TLSConfig{CA: []string{certFile}, Cert: certFile, Key: keyFile},
+		},
+		{
+			name:                 "key without cert",
+			tls:                  TLSConfig{Key: keyFile},
+			expectProvisionError: true,
+		},
+		{
+			name:                 "cert without key",
+			tls:                  TLSConfig{Cert: certFile},
+			expectProvisionError: true,
+		},
+		{
+			name:                 "cert/key and client_certificate",
+			tls:                  TLSConfig{Cert: certFile, Key: keyFile, ClientCertificate: "client.example.com"},
+			expectProvisionError: true,
+		},
+		{
+			name:               "missing ca file",
+			tls:                TLSConfig{CA: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+			expectOptionsError: true,
+		},
+		{
+			name:               "ca file without certificate",
+			tls:                TLSConfig{CA: []string{keyFile}},
+			expectOptionsError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			err := tt.tls.provision(ctx)
+			if tt.expectProvisionError {
+				if err == nil {
+					t.Errorf("expected a provisioning error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no provisioning error, but got: %v", err)
+			}
+
+			// the options are applied by nats.Connect, which fails for invalid files.
+			opts := nats.GetDefaultOptions()
+			for _, opt := range tt.tls.natsOptions() {
+				if err = opt(&opts); err != nil {
+					break
+				}
+			}
+			if tt.expectOptionsError {
+				if err == nil {
+					t.Errorf("expected an error applying the options, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error applying the options, but got: %v", err)
+			}
+			if !opts.Secure {
+				t.Errorf("expected a secure connection")
+			}
+			if opts.TLSConfig.ServerName != tt.tls.ServerName {
+				t.Errorf("expected server name %q, got %q", tt.tls.ServerName, opts.TLSConfig.ServerName)
+			}
+			if opts.TLSHandshakeFirst != tt.tls.HandshakeFirst {
+				t.Errorf("expected handshake first %v, got %v", tt.tls.HandshakeFirst, opts.TLSHandshakeFirst)
+			}
+			if (opts.RootCAsCB != nil) != (len(tt.tls.CA) > 0) {
+				t.Errorf("expected root CAs only if ca is configured")
+			}
+			if (opts.TLSCertCB != nil) != (tt.tls.Cert != "") {
+				t.Errorf("expected a client certificate only if cert is configured")
+			}
+		})
+	}
+}
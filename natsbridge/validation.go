@@ -0,0 +1,70 @@
+package natsbridge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// requiredAliases collects the server aliases which are referenced by modules provisioned before the nats app (f.e.
+// log outputs, which cannot access the app during provisioning), by config. The app validates them in Provision.
+var requiredAliases sync.Map // context.Context -> *aliasRequirements
+
+type aliasRequirements struct {
+	mu      sync.Mutex
+	modules map[string]string // alias -> module which references it
+}
+
+// RequireServerAlias registers a server alias which must be configured in the nats app of the config which is
+// currently provisioned; otherwise provisioning the app fails.
+func RequireServerAlias(ctx caddy.Context, alias string, module string) {
+	val, loaded := requiredAliases.LoadOrStore(ctx.Context, &aliasRequirements{modules: make(map[string]string)})
+	if !loaded {
+		// forget the requirements of configs which failed to load or were replaced.
+		context.AfterFunc(ctx, func() { requiredAliases.Delete(ctx.Context) })
+	}
+	reqs := val.(*aliasRequirements)
+	reqs.mu.Lock()
+	defer reqs.mu.Unlock()
+	reqs.modules[alias] = module
+}
+
+// Server returns the server with the given alias.
+func (app *NatsBridgeApp) Server(alias string) (*NatsServer, error) {
+	server, ok := app.Servers[alias]
+	if !ok {
+		return nil, fmt.Errorf("NATS server alias %s not found", alias)
+	}
+	return server, nil
+}
+
+// validateRequiredAliases checks all aliases registered via RequireServerAlias for the given config.
+func (app *NatsBridgeApp) validateRequiredAliases(ctx caddy.Context) error {
+	val, ok := requiredAliases.LoadAndDelete(ctx.Context)
+	if !ok {
+		return nil
+	}
+	reqs := val.(*aliasRequirements)
+	reqs.mu.Lock()
+	defer reqs.mu.Unlock()
+	for alias, module := range reqs.modules {
+		if _, err := app.Server(alias); err != nil {
+			return fmt.Errorf("%s: %w", module, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSubject checks a subject template of a handler bound to the server, including the subject prefix which
+// is effective for the handler.
+func (server *NatsServer) ValidateSubject(subject string, prefixOverride *string) error {
+	if prefixOverride != nil {
+		if err := common.ValidateSubjectPrefix(*prefixOverride); err != nil {
+			return err
+		}
+	}
+	return common.ValidateSubjectTemplate(server.Defaults().Subject(subject, prefixOverride))
+}
@@ -0,0 +1,38 @@
+package natsbridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestRequiredAliases(t *testing.T) {
+	app := &NatsBridgeApp{Servers: map[string]*NatsServer{"default": {}}}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	RequireServerAlias(ctx, "default", "log output a")
+	if err := app.validateRequiredAliases(ctx); err != nil {
+		t.Errorf("expected no error, but got: %v", err)
+	}
+
+	RequireServerAlias(ctx, "missing", "log output b")
+	if err := app.validateRequiredAliases(ctx); err == nil {
+		t.Errorf("expected an error, but got none")
+	}
+
+	otherCtx, otherCancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	RequireServerAlias(otherCtx, "missing", "log output c")
+	otherCancel()
+	// the requirements are removed asynchronously.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := requiredAliases.Load(otherCtx.Context); !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("expected the requirements of a cancelled config to be removed")
+}
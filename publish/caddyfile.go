@@ -1,6 +1,7 @@
 package publish
 
 import (
+	"github.com/CoverWhale/caddy-nats-bridge/common"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
@@ -9,7 +10,9 @@ import (
 // ParsePublishHandler parses the nats_publish directive. Syntax:
 //
 //	nats_publish [serverAlias] subject {
-//	    [timeout 42ms]
+//	    [metrics_subject label]
+//	    [subject_prefix prefix]
+//	    [header name value]
 //	}
 func ParsePublishHandler(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var p = Publish{}
@@ -31,6 +34,18 @@ func (p *Publish) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 		for d.NextBlock(0) {
 			switch d.Val() {
+			case "metrics_subject":
+				if !d.AllArgs(&p.MetricsSubject) {
+					return d.ArgErr()
+				}
+			case "subject_prefix":
+				if err := common.ParseSubjectPrefix(d, &p.SubjectPrefix); err != nil {
+					return err
+				}
+			case "header":
+				if err := common.ParseHeader(d, &p.Headers); err != nil {
+					return err
+				}
 			default:
 				return d.Errf("unrecognized subdirective: %s", d.Val())
 			}
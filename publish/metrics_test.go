@@ -0,0 +1,76 @@
+package publish
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func TestPublishMetrics(t *testing.T) {
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("greet.>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app := &natsbridge.NatsBridgeApp{Servers: map[string]*natsbridge.NatsServer{"default": {Conn: nc}}}
+	p := Publish{
+		Subject:        "greet.{http.request.uri.path.asNatsSubject}",
+		ServerAlias:    "default",
+		MetricsSubject: "greet",
+		app:            app,
+		logger:         zap.NewNop(),
+		metrics:        metrics,
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader("hello"))
+	repl := caddy.NewReplacer()
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+	if err := p.ServeHTTP(httptest.NewRecorder(), req, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("expected the published message, but got: %v", err)
+	}
+
+	published := &dto.Metric{}
+	if err := metrics.MessagesPublished.WithLabelValues("default", "greet", "nats_publish").Write(published); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if published.GetCounter().GetValue() != 1 {
+		t.Errorf("expected 1 published message, got %v", published.GetCounter().GetValue())
+	}
+	size := &dto.Metric{}
+	if err := metrics.PayloadSize.WithLabelValues("default", "greet", "nats_publish", common.PayloadDirectionOut).(prometheus.Metric).Write(size); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size.GetHistogram().GetSampleCount() != 1 || size.GetHistogram().GetSampleSum() != 5 {
+		t.Errorf("expected one payload of 5 bytes, got %d with %v bytes", size.GetHistogram().GetSampleCount(), size.GetHistogram().GetSampleSum())
+	}
+}
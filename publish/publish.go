@@ -15,9 +15,16 @@ import (
 type Publish struct {
 	Subject     string `json:"subject,omitempty"`
 	ServerAlias string `json:"serverAlias,omitempty"`
-
-	logger *zap.Logger
-	app    *natsbridge.NatsBridgeApp
+	// MetricsSubject is used as subject label for metrics; defaults to the (unexpanded) Subject.
+	MetricsSubject string `json:"metricsSubject,omitempty"`
+	// SubjectPrefix overrides the subject prefix of the server; an empty string disables it.
+	SubjectPrefix *string `json:"subjectPrefix,omitempty"`
+	// Headers are added to every message, overriding the headers of the server with the same name.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	logger  *zap.Logger
+	app     *natsbridge.NatsBridgeApp
+	metrics *common.Metrics
 }
 
 func (Publish) CaddyModule() caddy.ModuleInfo {
@@ -42,6 +49,22 @@ func (p *Publish) Provision(ctx caddy.Context) error {
 
 	p.app = natsAppIface.(*natsbridge.NatsBridgeApp)
 
+	server, err := p.app.Server(p.ServerAlias)
+	if err != nil {
+		return err
+	}
+	if err := server.ValidateSubject(p.Subject, p.SubjectPrefix); err != nil {
+		return err
+	}
+
+	p.metrics, err = common.GetMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+	if p.MetricsSubject == "" {
+		p.MetricsSubject = p.Subject
+	}
+
 	return nil
 }
 
@@ -49,27 +72,41 @@ func (p Publish) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 	common.AddNATSPublishVarsToReplacer(repl, r)
 
+	server, err := p.app.Server(p.ServerAlias)
+	if err != nil {
+		return err
+	}
+	defaults := server.Defaults()
+
 	//TODO: What method is best here? ReplaceAll vs ReplaceWithErr?
-	subj := repl.ReplaceAll(p.Subject, "")
+	subj := defaults.Subject(repl.ReplaceAll(p.Subject, ""), p.SubjectPrefix)
 
 	p.logger.Debug("publishing NATS message",
 		zap.String("subject", subj),
 		zap.Any("headers", common.RedactHeaders(r.Header)))
 
-	server, ok := p.app.Servers[p.ServerAlias]
-	if !ok {
-		return fmt.Errorf("NATS server alias %s not found", p.ServerAlias)
+	if server.Unavailable() {
+		w.WriteHeader(server.DisconnectedStatus)
+		p.logger.Warn("NATS server not connected - answering with configured HTTP status.",
+			zap.String("serverAlias", p.ServerAlias),
+			zap.Int("status", server.DisconnectedStatus))
+		return nil
 	}
 
 	msg, err := common.NatsMsgForHttpRequest(r, subj)
 	if err != nil {
 		return err
 	}
+	for k, v := range defaults.MergedHeaders(p.Headers) {
+		msg.Header.Set(k, v)
+	}
 
 	err = server.Conn.PublishMsg(msg)
 	if err != nil {
 		return fmt.Errorf("could not publish NATS message: %w", err)
 	}
+	p.metrics.MessagesPublished.WithLabelValues(p.ServerAlias, p.MetricsSubject, "nats_publish").Inc()
+	p.metrics.PayloadSize.WithLabelValues(p.ServerAlias, p.MetricsSubject, "nats_publish", common.PayloadDirectionOut).Observe(float64(len(msg.Data)))
 
 	// TODO: wiretap mode :) -> Response to NATS.
 	return next.ServeHTTP(w, r)
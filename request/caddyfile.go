@@ -3,6 +3,7 @@ package request
 import (
 	"time"
 
+	"github.com/CoverWhale/caddy-nats-bridge/common"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
@@ -12,6 +13,12 @@ import (
 //
 //	nats_request [serverAlias] subject {
 //	    [timeout 1s]
+//	    [metrics_subject label]
+//	    [subject_prefix prefix]
+//	    [header name value]
+//	    [fetch_body [bucket...] {
+//	        [delete]
+//	    }]
 //	}
 func ParseRequestHandler(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var p = Request{}
@@ -43,6 +50,24 @@ func (p *Request) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				}
 
 				p.Timeout = t
+			case "metrics_subject":
+				if !d.AllArgs(&p.MetricsSubject) {
+					return d.ArgErr()
+				}
+			case "subject_prefix":
+				if err := common.ParseSubjectPrefix(d, &p.SubjectPrefix); err != nil {
+					return err
+				}
+			case "header":
+				if err := common.ParseHeader(d, &p.Headers); err != nil {
+					return err
+				}
+			case "fetch_body":
+				fb, err := common.ParseFetchBody(d)
+				if err != nil {
+					return err
+				}
+				p.FetchBody = fb
 			default:
 				return d.Errf("unrecognized subdirective: %s", d.Val())
 			}
@@ -0,0 +1,58 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestDisconnectedStatus(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	// nothing listens on the url, so the connection is established in background.
+	app := &natsbridge.NatsBridgeApp{
+		Servers: map[string]*natsbridge.NatsServer{
+			"default": {
+				NatsUrl:            "nats://127.0.0.1:1",
+				ConnectMode:        natsbridge.ConnectModeAsync,
+				DisconnectedStatus: http.StatusBadGateway,
+			},
+		},
+	}
+	if err := app.Provision(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := app.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer app.Stop()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := Request{Subject: "greet", ServerAlias: "default", Timeout: time.Second, app: app, logger: zap.NewNop(), metrics: metrics}
+	req := httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader("hello"))
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+	rec := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+		t.Errorf("expected the next handler not to be called")
+		return nil
+	})
+
+	if err := p.ServeHTTP(rec, req, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, rec.Code)
+	}
+}
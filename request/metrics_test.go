@@ -0,0 +1,97 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+	sub, err := nc.Subscribe("greet.hello", func(msg *nats.Msg) {
+		_ = msg.Respond([]byte("hi there"))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app := &natsbridge.NatsBridgeApp{Servers: map[string]*natsbridge.NatsServer{"default": {Conn: nc}}}
+	p := Request{
+		Subject:        "greet.{http.request.uri.path.asNatsSubject}",
+		ServerAlias:    "default",
+		MetricsSubject: "greet",
+		Timeout:        time.Second,
+		app:            app,
+		logger:         zap.NewNop(),
+		metrics:        metrics,
+	}
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+
+	// one request which is answered, and one without responders.
+	for _, path := range []string{"/hello", "/nobody"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("hello"))
+		req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+		if err := p.ServeHTTP(httptest.NewRecorder(), req, next); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for outcome, expected := range map[string]float64{common.RequestOutcomeOk: 1, common.RequestOutcomeNoResponders: 1, common.RequestOutcomeTimeout: 0} {
+		requests := &dto.Metric{}
+		if err := metrics.Requests.WithLabelValues("default", "greet", outcome).Write(requests); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if requests.GetCounter().GetValue() != expected {
+			t.Errorf("expected %v requests with outcome %s, got %v", expected, outcome, requests.GetCounter().GetValue())
+		}
+	}
+
+	histograms := []struct {
+		name          string
+		observer      prometheus.Observer
+		expectedCount uint64
+		expectedSum   float64
+	}{
+		{"request duration", metrics.RequestDuration.WithLabelValues("default", "greet"), 2, -1},
+		{"request payload", metrics.PayloadSize.WithLabelValues("default", "greet", "nats_request", common.PayloadDirectionOut), 2, 10},
+		{"reply payload", metrics.PayloadSize.WithLabelValues("default", "greet", "nats_request", common.PayloadDirectionIn), 1, 8},
+	}
+	for _, h := range histograms {
+		m := &dto.Metric{}
+		if err := h.observer.(prometheus.Metric).Write(m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if m.GetHistogram().GetSampleCount() != h.expectedCount {
+			t.Errorf("expected %d observations of the %s, got %d", h.expectedCount, h.name, m.GetHistogram().GetSampleCount())
+		}
+		if h.expectedSum >= 0 && m.GetHistogram().GetSampleSum() != h.expectedSum {
+			t.Errorf("expected a sum of %v for the %s, got %v", h.expectedSum, h.name, m.GetHistogram().GetSampleSum())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// replyBody is the HTTP response body of a reply.
+type replyBody struct {
+	io.ReadCloser
+	// offloaded is the body fetched from the object store; nil if the body is the reply data.
+	offloaded *common.OffloadedBody
+}
+
+// replyBody returns the HTTP response body of the reply. Offloaded bodies are only fetched if FetchBody is
+// configured; on errors, the returned status should be sent to the client.
+func (p Request) replyBody(server *natsbridge.NatsServer, resp *nats.Msg) (*replyBody, int, error) {
+	inline := &replyBody{ReadCloser: io.NopCloser(bytes.NewReader(resp.Data))}
+	if p.FetchBody == nil {
+		return inline, 0, nil
+	}
+	offloaded, err := p.FetchBody.Fetch(resp.Header, server.JetStream)
+	if err != nil {
+		status := http.StatusBadGateway
+		var fetchErr *common.FetchError
+		if errors.As(err, &fetchErr) {
+			status = fetchErr.Status
+		}
+		return nil, status, err
+	}
+	if offloaded == nil {
+		return inline, 0, nil
+	}
+	return &replyBody{ReadCloser: offloaded, offloaded: offloaded}, 0, nil
+}
+
+// writeHeaders sets the headers describing an offloaded body, and removes the reference to it.
+func (b *replyBody) writeHeaders(header http.Header) {
+	if b.offloaded == nil {
+		return
+	}
+	info := b.offloaded.Info
+	header.Del(common.BodyBucketHeader)
+	header.Del(common.BodyIdHeader)
+	header.Set("Content-Length", strconv.FormatUint(info.Size, 10))
+	if contentType := info.Headers.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+}
+
+// deleteBody removes the object of an offloaded body after it was streamed to the client.
+func (p Request) deleteBody(b *replyBody) {
+	if b.offloaded == nil || !p.FetchBody.Delete {
+		return
+	}
+	if err := b.offloaded.Remove(); err != nil {
+		p.logger.Error("could not delete fetched body from object store",
+			zap.String("bucket", b.offloaded.Info.Bucket),
+			zap.String("id", b.offloaded.Info.Name),
+			zap.Error(err))
+	}
+}
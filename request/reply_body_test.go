@@ -0,0 +1,208 @@
+package request
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestReplyBody(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "responses"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	natsServer := &natsbridge.NatsServer{Conn: nc}
+
+	offloaded := func(bucket string, id string) *nats.Msg {
+		msg := nats.NewMsg("")
+		msg.Header.Set(common.BodyBucketHeader, bucket)
+		msg.Header.Set(common.BodyIdHeader, id)
+		return msg
+	}
+
+	tests := []struct {
+		name              string
+		fetchBody         *common.FetchBody
+		resp              *nats.Msg
+		expectBody        string
+		expectHeaders     map[string]string
+		expectDeleted     bool
+		expectErrorStatus int
+	}{
+		{
+			name:       "reply data",
+			fetchBody:  &common.FetchBody{},
+			resp:       &nats.Msg{Data: []byte("small file")},
+			expectBody: "small file",
+		},
+		{
+			name:       "offloaded body is not fetched without fetch_body",
+			resp:       offloaded("responses", "file"),
+			expectBody: "",
+		},
+		{
+			name:       "offloaded body",
+			fetchBody:  &common.FetchBody{Buckets: []string{"responses"}},
+			resp:       offloaded("responses", "file"),
+			expectBody: "large file",
+			expectHeaders: map[string]string{
+				"Content-Length": "10",
+				"Content-Type":   "application/pdf",
+			},
+		},
+		{
+			name:          "offloaded body is deleted",
+			fetchBody:     &common.FetchBody{Delete: true},
+			resp:          offloaded("responses", "file"),
+			expectBody:    "large file",
+			expectDeleted: true,
+		},
+		{
+			name:              "bucket not allowed",
+			fetchBody:         &common.FetchBody{Buckets: []string{"other"}},
+			resp:              offloaded("responses", "file"),
+			expectErrorStatus: http.StatusForbidden,
+		},
+		{
+			name:              "missing object",
+			fetchBody:         &common.FetchBody{},
+			resp:              offloaded("responses", "expired"),
+			expectErrorStatus: http.StatusGone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.Put(&nats.ObjectMeta{
+				Name:    "file",
+				Headers: nats.Header{"Content-Type": []string{"application/pdf"}},
+			}, strings.NewReader("large file"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			p := Request{FetchBody: tt.fetchBody, logger: zap.NewNop()}
+
+			body, status, err := p.replyBody(natsServer, tt.resp)
+			if tt.expectErrorStatus != 0 {
+				if err == nil {
+					t.Fatalf("expected an error, but got none")
+				}
+				if status != tt.expectErrorStatus {
+					t.Errorf("expected status %d, got %d", tt.expectErrorStatus, status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			w.Header().Set(common.BodyBucketHeader, "responses")
+			body.writeHeaders(w.Header())
+			if _, err := io.Copy(w, body); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body.Close()
+			p.deleteBody(body)
+
+			if w.Body.String() != tt.expectBody {
+				t.Errorf("expected body %q, got %q", tt.expectBody, w.Body.String())
+			}
+			for k, v := range tt.expectHeaders {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("expected header %s to be %s, got %s", k, v, got)
+				}
+			}
+			if body.offloaded != nil && w.Header().Get(common.BodyBucketHeader) != "" {
+				t.Errorf("expected the body reference to be removed from the response")
+			}
+			_, err = store.GetInfo("file")
+			if deleted := err != nil; deleted != tt.expectDeleted {
+				t.Errorf("expected deleted to be %v, got error %v", tt.expectDeleted, err)
+			}
+		})
+	}
+}
+
+func TestReplyBodyErrorHeaders(t *testing.T) {
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+	sub, err := nc.Subscribe("files", func(msg *nats.Msg) {
+		reply := nats.NewMsg(msg.Reply)
+		reply.Header.Set(common.BodyBucketHeader, "responses")
+		reply.Header.Set(common.BodyIdHeader, "file")
+		reply.Header.Set("X-Custom", "value")
+		_ = msg.RespondMsg(reply)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := Request{
+		Subject:     "files",
+		ServerAlias: "default",
+		Timeout:     time.Second,
+		FetchBody:   &common.FetchBody{Buckets: []string{"other"}},
+		app:         &natsbridge.NatsBridgeApp{Servers: map[string]*natsbridge.NatsServer{"default": {Conn: nc}}},
+		logger:      zap.NewNop(),
+		metrics:     metrics,
+	}
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error { return nil })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+	w := httptest.NewRecorder()
+	if err := p.ServeHTTP(w, req, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	for _, k := range []string{common.BodyBucketHeader, common.BodyIdHeader, "X-Custom"} {
+		if got := w.Header().Get(k); got != "" {
+			t.Errorf("expected header %s not to be in the error response, got %s", k, got)
+		}
+	}
+}
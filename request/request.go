@@ -3,6 +3,7 @@ package request
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -18,28 +19,40 @@ import (
 )
 
 type Request struct {
-	Subject     string        `json:"subject,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	// Timeout of the request. If not set, the default_timeout of the server is used; see DefaultTimeout otherwise.
 	Timeout     time.Duration `json:"timeout,omitempty"`
 	ServerAlias string        `json:"serverAlias,omitempty"`
+	// MetricsSubject is used as subject label for metrics; defaults to the (unexpanded) Subject.
+	MetricsSubject string `json:"metricsSubject,omitempty"`
+	// SubjectPrefix overrides the subject prefix of the server; an empty string disables it.
+	SubjectPrefix *string `json:"subjectPrefix,omitempty"`
+	// Headers are added to every request message, overriding the headers of the server with the same name.
+	Headers map[string]string `json:"headers,omitempty"`
+	// FetchBody streams reply bodies offloaded to a JetStream object store to the HTTP response.
+	FetchBody *common.FetchBody `json:"fetchBody,omitempty"`
+
+	logger  *zap.Logger
+	app     *natsbridge.NatsBridgeApp
+	metrics *common.Metrics
+}
 
-	logger *zap.Logger
-	app    *natsbridge.NatsBridgeApp
+// DefaultTimeout is the timeout of requests if neither the handler nor the server configure one: the
+// NATS_REQUEST_DEFAULT_TIMEOUT environment variable, or 60s as fallback.
+func DefaultTimeout() time.Duration {
+	if envTimeout := os.Getenv("NATS_REQUEST_DEFAULT_TIMEOUT"); envTimeout != "" {
+		if parsedTimeout, err := time.ParseDuration(envTimeout); err == nil {
+			return parsedTimeout
+		}
+	}
+	return 60 * time.Second
 }
 
 func (Request) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID: "http.handlers.nats_request",
 		New: func() caddy.Module {
-			// Get default timeout from environment variable or use 60s as fallback
-			defaultTimeout := 60 * time.Second
-			if envTimeout := os.Getenv("NATS_REQUEST_DEFAULT_TIMEOUT"); envTimeout != "" {
-				if parsedTimeout, err := time.ParseDuration(envTimeout); err == nil {
-					defaultTimeout = parsedTimeout
-				}
-			}
-
 			return &Request{
-				Timeout:     defaultTimeout,
 				ServerAlias: "default",
 			}
 		},
@@ -49,16 +62,6 @@ func (Request) CaddyModule() caddy.ModuleInfo {
 func (p *Request) Provision(ctx caddy.Context) error {
 	p.logger = ctx.Logger(p)
 
-	// Log if we're using environment variable timeout
-	if os.Getenv("NATS_REQUEST_DEFAULT_TIMEOUT") != "" {
-		p.logger.Info("using NATS request timeout from environment variable",
-			zap.String("timeout", p.Timeout.String()),
-			zap.String("env_var", "NATS_REQUEST_DEFAULT_TIMEOUT"))
-	} else {
-		p.logger.Info("using NATS request timeout from default",
-			zap.String("timeout", p.Timeout.String()))
-	}
-
 	natsAppIface, err := ctx.App("nats")
 	if err != nil {
 		return fmt.Errorf("getting NATS app: %v. Make sure NATS is configured in nats options", err)
@@ -66,24 +69,75 @@ func (p *Request) Provision(ctx caddy.Context) error {
 
 	p.app = natsAppIface.(*natsbridge.NatsBridgeApp)
 
+	server, err := p.app.Server(p.ServerAlias)
+	if err != nil {
+		return err
+	}
+	if err := server.ValidateSubject(p.Subject, p.SubjectPrefix); err != nil {
+		return err
+	}
+
+	p.resolveTimeout(server)
+
+	p.metrics, err = common.GetMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+	if p.MetricsSubject == "" {
+		p.MetricsSubject = p.Subject
+	}
+
 	return nil
 }
 
+// resolveTimeout sets the timeout of the request: the timeout of the handler wins over the default_timeout of the
+// server, which wins over the NATS_REQUEST_DEFAULT_TIMEOUT environment variable and the 60s fallback.
+func (p *Request) resolveTimeout(server *natsbridge.NatsServer) {
+	switch {
+	case p.Timeout > 0:
+		p.logger.Debug("using NATS request timeout from handler",
+			zap.String("timeout", p.Timeout.String()))
+	case server.DefaultTimeout != nil:
+		p.Timeout = *server.DefaultTimeout
+		p.logger.Info("using NATS request timeout from server default",
+			zap.String("timeout", p.Timeout.String()),
+			zap.String("serverAlias", p.ServerAlias))
+	case os.Getenv("NATS_REQUEST_DEFAULT_TIMEOUT") != "":
+		p.Timeout = DefaultTimeout()
+		p.logger.Info("using NATS request timeout from environment variable",
+			zap.String("timeout", p.Timeout.String()),
+			zap.String("env_var", "NATS_REQUEST_DEFAULT_TIMEOUT"))
+	default:
+		p.Timeout = DefaultTimeout()
+		p.logger.Info("using NATS request timeout from default",
+			zap.String("timeout", p.Timeout.String()))
+	}
+}
+
 func (p Request) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 	common.AddNATSPublishVarsToReplacer(repl, r)
 
+	server, err := p.app.Server(p.ServerAlias)
+	if err != nil {
+		return err
+	}
+	defaults := server.Defaults()
+
 	//TODO: What method is best here? ReplaceAll vs ReplaceWithErr?
-	subj := repl.ReplaceAll(p.Subject, "")
+	subj := defaults.Subject(repl.ReplaceAll(p.Subject, ""), p.SubjectPrefix)
 
 	//p.logger.Debug("publishing NATS message", zap.String("subject", subj), zap.Bool("with_reply", p.WithReply), zap.Int64("timeout", p.Timeout))
 	p.logger.Debug("publishing NATS message",
 		zap.String("subject", subj),
 		zap.Any("headers", common.RedactHeaders(r.Header)))
 
-	server, ok := p.app.Servers[p.ServerAlias]
-	if !ok {
-		return fmt.Errorf("NATS server alias %s not found", p.ServerAlias)
+	if server.Unavailable() {
+		w.WriteHeader(server.DisconnectedStatus)
+		p.logger.Warn("NATS server not connected - answering with configured HTTP status.",
+			zap.String("serverAlias", p.ServerAlias),
+			zap.Int("status", server.DisconnectedStatus))
+		return nil
 	}
 
 	msg, err := common.NatsMsgForHttpRequest(r, subj)
@@ -92,40 +146,66 @@ func (p Request) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 		p.logger.Warn(fmt.Sprintf("Request sent with invalid characters %v", err.Error()))
 		return nil
 	}
+	for k, v := range defaults.MergedHeaders(p.Headers) {
+		msg.Header.Set(k, v)
+	}
 
 	start := time.Now()
 	defer func() {
 		p.logger.Debug("http_request", zap.String("duration", fmt.Sprintf("%d ms", time.Since(start).Milliseconds())))
 	}()
 
+	p.metrics.PayloadSize.WithLabelValues(p.ServerAlias, p.MetricsSubject, "nats_request", common.PayloadDirectionOut).Observe(float64(len(msg.Data)))
+	done := server.TrackRequest()
 	resp, err := server.Conn.RequestMsg(msg, p.Timeout)
+	done()
+	p.metrics.RequestDuration.WithLabelValues(p.ServerAlias, p.MetricsSubject).Observe(time.Since(start).Seconds())
 	if err != nil && errors.Is(err, nats.ErrNoResponders) {
+		p.metrics.Requests.WithLabelValues(p.ServerAlias, p.MetricsSubject, common.RequestOutcomeNoResponders).Inc()
 		w.WriteHeader(http.StatusNotFound)
 		p.logger.Warn("No Responders for NATS subject - answering with HTTP Status Not Found.")
 		return nil
 	}
 	p.logger.Debug("nats_request", zap.String("duration", fmt.Sprintf("%d ms", time.Since(start).Milliseconds())))
 	if err != nil && errors.Is(err, nats.ErrTimeout) {
+		p.metrics.Requests.WithLabelValues(p.ServerAlias, p.MetricsSubject, common.RequestOutcomeTimeout).Inc()
 		w.WriteHeader(http.StatusGatewayTimeout)
 		p.logger.Warn("Request timed out", zap.String("subject", subj))
 		return nil
 	}
 	if err != nil {
+		p.metrics.Requests.WithLabelValues(p.ServerAlias, p.MetricsSubject, common.RequestOutcomeError).Inc()
 		w.WriteHeader(http.StatusInternalServerError)
 		return fmt.Errorf("could not request NATS message: %w", err)
 	}
+	p.metrics.Requests.WithLabelValues(p.ServerAlias, p.MetricsSubject, common.RequestOutcomeOk).Inc()
+	p.metrics.PayloadSize.WithLabelValues(p.ServerAlias, p.MetricsSubject, "nats_request", common.PayloadDirectionIn).Observe(float64(len(resp.Data)))
+
+	// the body is fetched before the reply headers are copied, so an error response does not contain them.
+	body, status, err := p.replyBody(server, resp)
+	if err != nil {
+		w.WriteHeader(status)
+		p.logger.Error("could not fetch offloaded reply body", zap.String("subject", subj), zap.Error(err))
+		return nil
+	}
+	defer body.Close()
 
 	for k, headers := range resp.Header {
 		// strip out these headers from the response
-		if k == "Nats-Service-Error" || k == "Nats-Service-Error-Code" || k == "nats-service-error" || k == "nats-service-error-code" || k == "Content-Length" {
+		if k == "Nats-Service-Error" || k == "Nats-Service-Error-Code" || k == "nats-service-error" || k == "nats-service-error-code" || k == "X-NatsBridge-Status" || k == "Content-Length" {
 			continue
 		}
 		for _, header := range headers {
 			w.Header().Add(k, header)
 		}
 	}
+	body.writeHeaders(w.Header())
 
 	code := resp.Header.Get("Nats-Service-Error-Code")
+	if code == "" {
+		// f.e. a 201 or 204 of a subscribe handler of another bridge.
+		code = resp.Header.Get("X-NatsBridge-Status")
+	}
 	if code != "" && code != "200" {
 		status, err := strconv.Atoi(code)
 		if err != nil {
@@ -135,10 +215,14 @@ func (p Request) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 		w.WriteHeader(status)
 	}
 
-	_, err = w.Write(resp.Data)
+	n, err := io.Copy(w, body)
 	if err != nil {
 		return fmt.Errorf("could not write response back to HTTP Writer: %w", err)
 	}
+	if body.offloaded != nil {
+		p.metrics.PayloadSize.WithLabelValues(p.ServerAlias, p.MetricsSubject, "nats_request_fetch_body", common.PayloadDirectionIn).Observe(float64(n))
+		p.deleteBody(body)
+	}
 
 	// we are done :)
 	return nil
@@ -216,15 +216,13 @@ func TestRequestTimeoutFromEnvVar(t *testing.T) {
 				os.Unsetenv("NATS_REQUEST_DEFAULT_TIMEOUT")
 			}
 
-			var req request.Request
-			module := req.CaddyModule()
-			instance := module.New().(*request.Request)
+			timeout := request.DefaultTimeout()
 
-			if instance.Timeout != tc.expectedResult {
-				t.Errorf("expected timeout %v, got %v", tc.expectedResult, instance.Timeout)
+			if timeout != tc.expectedResult {
+				t.Errorf("expected timeout %v, got %v", tc.expectedResult, timeout)
 			}
 
-			if tc.shouldParse && instance.Timeout == 60*time.Second && tc.envValue != "" {
+			if tc.shouldParse && timeout == 60*time.Second && tc.envValue != "" {
 				t.Errorf("expected timeout to be parsed from env var %s, but got default", tc.envValue)
 			}
 		})
@@ -0,0 +1,67 @@
+package request
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/natsbridge"
+	"go.uber.org/zap"
+)
+
+func TestResolveTimeout(t *testing.T) {
+	serverDefault := 5 * time.Second
+
+	tests := []struct {
+		name            string
+		timeout         time.Duration
+		serverDefault   *time.Duration
+		env             string
+		expectedTimeout time.Duration
+	}{
+		{
+			name:            "handler timeout wins over server default",
+			timeout:         10 * time.Second,
+			serverDefault:   &serverDefault,
+			expectedTimeout: 10 * time.Second,
+		},
+		{
+			name:            "handler timeout equal to the fallback wins over server default",
+			timeout:         60 * time.Second,
+			serverDefault:   &serverDefault,
+			expectedTimeout: 60 * time.Second,
+		},
+		{
+			name:            "handler timeout equal to the env var wins over server default",
+			timeout:         30 * time.Second,
+			serverDefault:   &serverDefault,
+			env:             "30s",
+			expectedTimeout: 30 * time.Second,
+		},
+		{
+			name:            "server default wins over env var",
+			serverDefault:   &serverDefault,
+			env:             "30s",
+			expectedTimeout: 5 * time.Second,
+		},
+		{
+			name:            "env var",
+			env:             "30s",
+			expectedTimeout: 30 * time.Second,
+		},
+		{
+			name:            "fallback",
+			expectedTimeout: 60 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NATS_REQUEST_DEFAULT_TIMEOUT", tt.env)
+			p := &Request{Timeout: tt.timeout, logger: zap.NewNop()}
+			p.resolveTimeout(&natsbridge.NatsServer{DefaultTimeout: tt.serverDefault})
+			if p.Timeout != tt.expectedTimeout {
+				t.Errorf("expected timeout %v, got %v", tt.expectedTimeout, p.Timeout)
+			}
+		})
+	}
+}
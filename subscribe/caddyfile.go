@@ -1,13 +1,44 @@
 package subscribe
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
 )
 
 // ParseSubscribeHandler parses the subscribe directive. Syntax:
 //
 //	subscribe subjectPattern HTTPMethod HTTPURL {
 //	    [queue queueGroupName]
+//	    [metrics_subject label]
+//	    [concurrency n]
+//	    [saturation_policy block|drop|reply_503]
+//	    [pending_limits msgs bytes]
+//	    [subject_prefix prefix]
+//	    [header name value]
+//	    [jetstream stream durable {
+//	        [mode pull|push]
+//	        [ack_wait duration]
+//	        [max_deliver n]
+//	        [backoff duration...]
+//	        [term_status code...]
+//	        [fetch_batch n]
+//	    }]
+//	    [dead_letter subject {
+//	        [attempts n]
+//	        [jetstream]
+//	    }]
+//	    [fetch_body [bucket...] {
+//	        [delete]
+//	    }]
+//	    [offload_response [bucket] {
+//	        [threshold size]
+//	        [ttl duration]
+//	    }]
 //	}
 func ParseSubscribeHandler(d *caddyfile.Dispenser) (*Subscribe, error) {
 	s := Subscribe{}
@@ -21,6 +52,69 @@ func ParseSubscribeHandler(d *caddyfile.Dispenser) (*Subscribe, error) {
 			if !d.AllArgs(&s.QueueGroup) {
 				return nil, d.ArgErr()
 			}
+		case "metrics_subject":
+			if !d.AllArgs(&s.MetricsSubject) {
+				return nil, d.ArgErr()
+			}
+		case "concurrency":
+			if err := common.ParseInt(d, &s.Concurrency); err != nil {
+				return nil, err
+			}
+		case "saturation_policy":
+			if !d.AllArgs(&s.SaturationPolicy) {
+				return nil, d.ArgErr()
+			}
+		case "pending_limits":
+			var msgs, size string
+			if !d.AllArgs(&msgs, &size) {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(msgs)
+			if err != nil {
+				return nil, d.Errf("pending message limit must be a number: %v", err)
+			}
+			s.PendingMsgsLimit = n
+			if size == "-1" {
+				s.PendingBytesLimit = -1
+			} else {
+				bytes, err := humanize.ParseBytes(size)
+				if err != nil {
+					return nil, d.Errf("pending bytes limit %s is not a valid size: %v", size, err)
+				}
+				s.PendingBytesLimit = int(bytes)
+			}
+		case "subject_prefix":
+			if err := common.ParseSubjectPrefix(d, &s.SubjectPrefix); err != nil {
+				return nil, err
+			}
+		case "header":
+			if err := common.ParseHeader(d, &s.Headers); err != nil {
+				return nil, err
+			}
+		case "jetstream":
+			c, err := parseJetStreamConsumer(d)
+			if err != nil {
+				return nil, err
+			}
+			s.JetStream = c
+		case "dead_letter":
+			dl, err := parseDeadLetter(d)
+			if err != nil {
+				return nil, err
+			}
+			s.DeadLetter = dl
+		case "fetch_body":
+			fb, err := common.ParseFetchBody(d)
+			if err != nil {
+				return nil, err
+			}
+			s.FetchBody = fb
+		case "offload_response":
+			o, err := parseOffloadResponse(d)
+			if err != nil {
+				return nil, err
+			}
+			s.OffloadResponse = o
 		default:
 			return nil, d.Errf("unrecognized subdirective: %s", d.Val())
 		}
@@ -28,3 +122,135 @@ func ParseSubscribeHandler(d *caddyfile.Dispenser) (*Subscribe, error) {
 
 	return &s, nil
 }
+
+func parseJetStreamConsumer(d *caddyfile.Dispenser) (*JetStreamConsumer, error) {
+	c := JetStreamConsumer{}
+	if !d.Args(&c.Stream, &c.Durable) {
+		return nil, d.ArgErr()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "mode":
+			if !d.AllArgs(&c.Mode) {
+				return nil, d.ArgErr()
+			}
+		case "ack_wait":
+			var val string
+			if !d.AllArgs(&val) {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return nil, d.Errf("ack_wait %s is not a valid duration: %v", val, err)
+			}
+			c.AckWait = dur
+		case "max_deliver":
+			if err := common.ParseInt(d, &c.MaxDeliver); err != nil {
+				return nil, err
+			}
+		case "fetch_batch":
+			if err := common.ParseInt(d, &c.FetchBatch); err != nil {
+				return nil, err
+			}
+		case "backoff":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			for _, arg := range args {
+				dur, err := caddy.ParseDuration(arg)
+				if err != nil {
+					return nil, d.Errf("backoff %s is not a valid duration: %v", arg, err)
+				}
+				c.Backoff = append(c.Backoff, time.Duration(dur))
+			}
+		case "term_status":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			for _, arg := range args {
+				code, err := strconv.Atoi(arg)
+				if err != nil {
+					return nil, d.Errf("term_status %s is not a valid HTTP status: %v", arg, err)
+				}
+				c.TermStatus = append(c.TermStatus, code)
+			}
+		default:
+			return nil, d.Errf("unrecognized jetstream subdirective: %s", d.Val())
+		}
+	}
+
+	return &c, nil
+}
+
+func parseDeadLetter(d *caddyfile.Dispenser) (*DeadLetter, error) {
+	dl := DeadLetter{}
+	if !d.Args(&dl.Subject) {
+		return nil, d.ArgErr()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "attempts":
+			if err := common.ParseInt(d, &dl.Attempts); err != nil {
+				return nil, err
+			}
+		case "jetstream":
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dl.JetStream = true
+		default:
+			return nil, d.Errf("unrecognized dead_letter subdirective: %s", d.Val())
+		}
+	}
+
+	return &dl, nil
+}
+
+func parseOffloadResponse(d *caddyfile.Dispenser) (*OffloadResponse, error) {
+	o := OffloadResponse{}
+	if d.NextArg() {
+		o.Bucket = d.Val()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "threshold":
+			var val string
+			if !d.AllArgs(&val) {
+				return nil, d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(val)
+			if err != nil {
+				return nil, d.Errf("threshold %s is not a valid size: %v", val, err)
+			}
+			o.Threshold = int64(size)
+		case "ttl":
+			var val string
+			if !d.AllArgs(&val) {
+				return nil, d.ArgErr()
+			}
+			ttl, err := caddy.ParseDuration(val)
+			if err != nil {
+				return nil, d.Errf("ttl %s is not a valid duration: %v", val, err)
+			}
+			o.TTL = ttl
+		default:
+			return nil, d.Errf("unrecognized offload_response subdirective: %s", d.Val())
+		}
+	}
+
+	return &o, nil
+}
@@ -0,0 +1,89 @@
+package subscribe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func TestSaturationPolicy(t *testing.T) {
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		policy       string
+		expectStatus string
+	}{
+		{policy: SaturationPolicyReply503, expectStatus: "503"},
+		{policy: SaturationPolicyDrop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s := &Subscribe{
+				Subject:          "busy." + tt.policy,
+				MetricsSubject:   "busy",
+				Concurrency:      1,
+				SaturationPolicy: tt.policy,
+				subject:          "busy." + tt.policy,
+				serverAlias:      "default",
+				logger:           zap.NewNop(),
+				metrics:          metrics,
+				inFlight:         &common.InFlight{},
+				workers:          make(chan struct{}, 1),
+			}
+			// all workers are busy.
+			s.workers <- struct{}{}
+
+			sub, err := nc.Subscribe(s.subject, s.dispatch)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer sub.Unsubscribe()
+
+			resp, err := nc.Request(s.subject, []byte("payload"), 200*time.Millisecond)
+			if tt.expectStatus == "" {
+				if err == nil {
+					t.Errorf("expected the message to be dropped, but got a response")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("expected a response, but got: %v", err)
+				}
+				if code := resp.Header.Get("Nats-Service-Error-Code"); code != tt.expectStatus {
+					t.Errorf("expected Nats-Service-Error-Code %s, got %s", tt.expectStatus, code)
+				}
+			}
+
+			rejected := &dto.Metric{}
+			if err := metrics.SubscribeRejected.WithLabelValues("default", "busy", tt.policy).Write(rejected); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rejected.GetCounter().GetValue() != 1 {
+				t.Errorf("expected 1 rejected message, got %v", rejected.GetCounter().GetValue())
+			}
+			if abandoned := s.inFlight.Wait(context.Background()); abandoned != 0 {
+				t.Errorf("expected no in-flight messages, got %d", abandoned)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package subscribe
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// Headers added to dead-lettered messages, next to the original headers.
+const (
+	DeadLetterSubjectHeader  = "X-NatsBridge-DeadLetter-Subject"
+	DeadLetterStatusHeader   = "X-NatsBridge-DeadLetter-Status"
+	DeadLetterErrorHeader    = "X-NatsBridge-DeadLetter-Error"
+	DeadLetterAttemptsHeader = "X-NatsBridge-DeadLetter-Attempts"
+	DeadLetterRouteHeader    = "X-NatsBridge-DeadLetter-Route"
+)
+
+// DeadLetter republishes messages which could not be dispatched to HTTP, so they can be inspected and replayed.
+//
+// For core NATS subscriptions, a message fails if it could not be dispatched to a Caddy server or the response has a
+// 5xx status; it is dispatched up to Attempts times before it is dead-lettered. Messages of JetStream consumers are
+// dead-lettered when they are given up on: after Attempts deliveries, or when they are terminated because of their
+// status.
+type DeadLetter struct {
+	// Subject is the subject the failed message is published to. It supports the placeholders of the subscribe
+	// URL, f.e. `dlq.{nats.request.subject}`, and the subject prefix of the server.
+	Subject string `json:"subject,omitempty"`
+	// Attempts is the number of attempts after which a message is dead-lettered. Defaults to 1 for core NATS
+	// subscriptions, and to the max_deliver of JetStream consumers.
+	Attempts int `json:"attempts,omitempty"`
+	// JetStream publishes the message to the JetStream stream bound to Subject, and fails if no stream
+	// acknowledges it.
+	JetStream bool `json:"jetstream,omitempty"`
+}
+
+func (dl *DeadLetter) provision(consumer *JetStreamConsumer) error {
+	if err := common.ValidateSubjectTemplate(dl.Subject); err != nil {
+		return fmt.Errorf("dead_letter: %w", err)
+	}
+	if dl.Attempts < 0 {
+		return fmt.Errorf("dead_letter: attempts must not be negative")
+	}
+	if consumer != nil && consumer.MaxDeliver > 0 && dl.Attempts > consumer.MaxDeliver {
+		return fmt.Errorf("dead_letter: attempts must not be greater than max_deliver of the JetStream consumer")
+	}
+	return nil
+}
+
+// maxAttempts returns the number of times a core NATS message is dispatched until it is dead-lettered.
+func (dl *DeadLetter) maxAttempts() int {
+	if dl == nil || dl.Attempts == 0 {
+		return 1
+	}
+	return dl.Attempts
+}
+
+// dispatchResult is the outcome of dispatching a message to HTTP.
+type dispatchResult struct {
+	// reply is the response to send back; nil if the response was not recorded.
+	reply *nats.Msg
+	// status is the HTTP status, or the status of the error reply if the message could not be dispatched.
+	status   int
+	err      error
+	route    string
+	attempts int
+}
+
+// failed reports if the message should be retried or dead-lettered; client errors are not retried.
+func (r *dispatchResult) failed() bool {
+	return r.err != nil || r.status >= 500
+}
+
+// permanent reports if the message failed in a way which can never succeed on retry: its offloaded body is not
+// allowed to be fetched, or is gone. Such messages are dead-lettered right away.
+func (r *dispatchResult) permanent() bool {
+	var fetchErr *common.FetchError
+	return errors.As(r.err, &fetchErr) && fetchErr.Status < 500
+}
+
+// deadLetter publishes the failed message, including the failure metadata, to the dead-letter subject. It reports
+// if the message was published.
+func (s *Subscribe) deadLetter(msg *nats.Msg, repl *caddy.Replacer, res *dispatchResult) bool {
+	dl := s.DeadLetter
+	subject := s.defaults.Subject(repl.ReplaceAll(dl.Subject, ""), s.SubjectPrefix)
+
+	out := nats.NewMsg(subject)
+	out.Data = msg.Data
+	for k, v := range msg.Header {
+		out.Header[k] = v
+	}
+	out.Header.Set(DeadLetterSubjectHeader, msg.Subject)
+	out.Header.Set(DeadLetterStatusHeader, strconv.Itoa(res.status))
+	out.Header.Set(DeadLetterAttemptsHeader, strconv.Itoa(res.attempts))
+	out.Header.Set(DeadLetterRouteHeader, res.route)
+	if res.err != nil {
+		out.Header.Set(DeadLetterErrorHeader, res.err.Error())
+	} else {
+		out.Header.Set(DeadLetterErrorHeader, strconv.Itoa(res.status)+" "+http.StatusText(res.status))
+	}
+
+	err := s.publishDeadLetter(out)
+	fields := []zap.Field{
+		zap.String("subject", msg.Subject),
+		zap.String("dead_letter_subject", subject),
+		zap.Int("status", res.status),
+		zap.Int("attempts", res.attempts),
+	}
+	if err != nil {
+		s.metrics.DeadLettered.WithLabelValues(s.serverAlias, s.MetricsSubject, common.RequestOutcomeError).Inc()
+		s.logger.Error("could not dead-letter NATS message", append(fields, zap.Error(err))...)
+		return false
+	}
+	s.metrics.DeadLettered.WithLabelValues(s.serverAlias, s.MetricsSubject, common.RequestOutcomeOk).Inc()
+	s.logger.Warn("dead-lettered NATS message", fields...)
+	return true
+}
+
+func (s *Subscribe) publishDeadLetter(msg *nats.Msg) error {
+	if !s.DeadLetter.JetStream {
+		return s.conn.PublishMsg(msg)
+	}
+	if s.defaults.JetStream == nil {
+		return fmt.Errorf("JetStream is not available for server %s", s.serverAlias)
+	}
+	js, err := s.defaults.JetStream()
+	if err != nil {
+		return err
+	}
+	_, err = js.PublishMsg(msg)
+	return err
+}
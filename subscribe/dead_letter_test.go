@@ -0,0 +1,146 @@
+package subscribe
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestDeadLetterProvision(t *testing.T) {
+	tests := []struct {
+		name        string
+		deadLetter  DeadLetter
+		consumer    *JetStreamConsumer
+		expectError bool
+	}{
+		{
+			name:       "placeholder subject",
+			deadLetter: DeadLetter{Subject: "dlq.{nats.request.subject}", Attempts: 3},
+		},
+		{
+			name:        "missing subject",
+			deadLetter:  DeadLetter{Attempts: 3},
+			expectError: true,
+		},
+		{
+			name:        "invalid subject",
+			deadLetter:  DeadLetter{Subject: "dlq.>"},
+			expectError: true,
+		},
+		{
+			name:        "negative attempts",
+			deadLetter:  DeadLetter{Subject: "dlq", Attempts: -1},
+			expectError: true,
+		},
+		{
+			name:        "more attempts than deliveries",
+			deadLetter:  DeadLetter{Subject: "dlq", Attempts: 5},
+			consumer:    &JetStreamConsumer{MaxDeliver: 3},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.deadLetter.provision(tt.consumer)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeadLetterAfterAttempts(t *testing.T) {
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prefix := "prod."
+	s := &Subscribe{
+		Subject:        "orders.>",
+		Method:         "POST",
+		URL:            "http://127.0.0.1:9999/{nats.request.subject.asUriPath}",
+		MetricsSubject: "orders",
+		SubjectPrefix:  &prefix,
+		DeadLetter:     &DeadLetter{Subject: "dlq.{nats.request.subject}", Attempts: 2},
+		subject:        "prod.orders.>",
+		conn:           nc,
+		serverAlias:    "default",
+		logger:         zap.NewNop(),
+		metrics:        metrics,
+		inFlight:       &common.InFlight{},
+		// no server matches, so every attempt fails.
+		httpApp: &caddyhttp.App{Servers: map[string]*caddyhttp.Server{}},
+	}
+
+	dlq, err := nc.SubscribeSync("prod.dlq.>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sub, err := nc.Subscribe(s.subject, s.dispatch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	msg := nats.NewMsg("prod.orders.created")
+	msg.Data = []byte("payload")
+	msg.Header.Set("X-Order", "42")
+	if err := nc.PublishMsg(msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dead, err := dlq.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatalf("expected a dead-lettered message, but got: %v", err)
+	}
+	if dead.Subject != "prod.dlq.prod.orders.created" {
+		t.Errorf("expected subject prod.dlq.prod.orders.created, got %s", dead.Subject)
+	}
+	if string(dead.Data) != "payload" {
+		t.Errorf("expected the original payload, got %s", dead.Data)
+	}
+	expected := map[string]string{
+		"X-Order":                "42",
+		DeadLetterSubjectHeader:  "prod.orders.created",
+		DeadLetterStatusHeader:   "404",
+		DeadLetterAttemptsHeader: "2",
+		DeadLetterRouteHeader:    "POST http://127.0.0.1:9999/prod/orders/created",
+	}
+	for k, v := range expected {
+		if got := dead.Header.Get(k); got != v {
+			t.Errorf("expected header %s to be %s, got %s", k, v, got)
+		}
+	}
+	if got := dead.Header.Get(DeadLetterErrorHeader); !strings.Contains(got, "no server matched") {
+		t.Errorf("expected the dispatch error, got %s", got)
+	}
+	if got := dead.Header.Values("User-Agent"); len(got) != 0 {
+		t.Errorf("expected the original headers only, got User-Agent %v", got)
+	}
+}
@@ -0,0 +1,61 @@
+package subscribe
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// messageBody is the HTTP request body of a message.
+type messageBody struct {
+	io.ReadCloser
+	size int64
+	// offloaded is the body fetched from the object store; nil if the body is the message data.
+	offloaded *common.OffloadedBody
+}
+
+// body returns the HTTP request body of the message. Offloaded bodies are only fetched if FetchBody is configured;
+// errors are of type *common.FetchError.
+func (s *Subscribe) body(msg *nats.Msg) (*messageBody, error) {
+	inline := &messageBody{ReadCloser: io.NopCloser(bytes.NewReader(msg.Data)), size: int64(len(msg.Data))}
+	if s.FetchBody == nil {
+		return inline, nil
+	}
+	offloaded, err := s.FetchBody.Fetch(msg.Header, s.defaults.JetStream)
+	if err != nil {
+		return nil, err
+	}
+	if offloaded == nil {
+		return inline, nil
+	}
+
+	s.metrics.PayloadSize.WithLabelValues(s.serverAlias, s.MetricsSubject, "subscribe_fetch_body", common.PayloadDirectionIn).Observe(float64(offloaded.Info.Size))
+	return &messageBody{ReadCloser: offloaded, size: int64(offloaded.Info.Size), offloaded: offloaded}, nil
+}
+
+// fetchErrorReason is the description of the error reply if an offloaded body cannot be fetched. A missing body will
+// never appear, so it is reported as client error and not retried.
+func fetchErrorReason(status int) string {
+	switch status {
+	case http.StatusForbidden:
+		return "body bucket not allowed"
+	case http.StatusGone:
+		return "body not found"
+	default:
+		return "could not fetch body"
+	}
+}
+
+// deleteBody removes the object of an offloaded body after it was dispatched successfully.
+func (s *Subscribe) deleteBody(body *messageBody, status int) {
+	if body.offloaded == nil || !s.FetchBody.Delete || status < 200 || status > 299 {
+		return
+	}
+	if err := body.offloaded.Remove(); err != nil {
+		s.logger.Error("could not delete fetched body from object store", zap.String("id", body.offloaded.Info.Name), zap.Error(err))
+	}
+}
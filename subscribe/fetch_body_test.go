@@ -0,0 +1,166 @@
+package subscribe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestFetchBody(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "bodies"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.PutBytes("body-1", []byte("large body")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	offloaded := func(bucket string, id string) *nats.Msg {
+		msg := nats.NewMsg("uploads.created")
+		msg.Header.Set(common.BodyBucketHeader, bucket)
+		msg.Header.Set(common.BodyIdHeader, id)
+		return msg
+	}
+
+	tests := []struct {
+		name         string
+		fetchBody    *common.FetchBody
+		msg          *nats.Msg
+		expectBody   string
+		expectStatus int
+	}{
+		{
+			name:       "message data",
+			fetchBody:  &common.FetchBody{},
+			msg:        &nats.Msg{Subject: "uploads.created", Data: []byte("small body")},
+			expectBody: "small body",
+		},
+		{
+			name:       "offloaded body is not fetched without fetch_body",
+			msg:        offloaded("bodies", "body-1"),
+			expectBody: "",
+		},
+		{
+			name:       "offloaded body",
+			fetchBody:  &common.FetchBody{},
+			msg:        offloaded("bodies", "body-1"),
+			expectBody: "large body",
+		},
+		{
+			name:       "allowed bucket",
+			fetchBody:  &common.FetchBody{Buckets: []string{"bodies"}},
+			msg:        offloaded("bodies", "body-1"),
+			expectBody: "large body",
+		},
+		{
+			name:         "bucket not allowed",
+			fetchBody:    &common.FetchBody{Buckets: []string{"other"}},
+			msg:          offloaded("bodies", "body-1"),
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "missing object",
+			fetchBody:    &common.FetchBody{},
+			msg:          offloaded("bodies", "expired"),
+			expectStatus: http.StatusGone,
+		},
+		{
+			name:         "missing bucket",
+			fetchBody:    &common.FetchBody{},
+			msg:          offloaded("unknown", "body-1"),
+			expectStatus: http.StatusGone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Subscribe{
+				FetchBody: tt.fetchBody,
+				defaults: common.ServerDefaults{
+					JetStream: func() (nats.JetStreamContext, error) { return js, nil },
+				},
+				serverAlias: "default",
+				logger:      zap.NewNop(),
+				metrics:     metrics,
+			}
+
+			body, err := s.body(tt.msg)
+			if tt.expectStatus != 0 {
+				var fetchErr *common.FetchError
+				if !errors.As(err, &fetchErr) {
+					t.Fatalf("expected a fetch error, but got: %v", err)
+				}
+				if fetchErr.Status != tt.expectStatus {
+					t.Errorf("expected status %d, got %d", tt.expectStatus, fetchErr.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			defer body.Close()
+
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(b) != tt.expectBody {
+				t.Errorf("expected body %q, got %q", tt.expectBody, b)
+			}
+			if body.size != int64(len(tt.expectBody)) {
+				t.Errorf("expected size %d, got %d", len(tt.expectBody), body.size)
+			}
+		})
+	}
+}
+
+func TestDeleteBody(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "bodies"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Subscribe{FetchBody: &common.FetchBody{Delete: true}, logger: zap.NewNop()}
+
+	tests := []struct {
+		status        int
+		expectDeleted bool
+	}{
+		{status: http.StatusInternalServerError, expectDeleted: false},
+		{status: http.StatusNoContent, expectDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			if _, err := store.PutBytes("body-1", []byte("large body")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			info, err := store.GetInfo("body-1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			s.deleteBody(&messageBody{offloaded: &common.OffloadedBody{Info: info, Store: store}}, tt.status)
+
+			_, err = store.GetInfo("body-1")
+			if deleted := errors.Is(err, nats.ErrObjectNotFound); deleted != tt.expectDeleted {
+				t.Errorf("expected deleted to be %v, got error %v", tt.expectDeleted, err)
+			}
+		})
+	}
+}
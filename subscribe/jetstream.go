@@ -0,0 +1,204 @@
+package subscribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	ConsumerModePull = "pull"
+	ConsumerModePush = "push"
+
+	// fetchWait is the maximum time a pull request waits for messages, before the next one is sent.
+	fetchWait = 5 * time.Second
+)
+
+// JetStreamConsumer binds a subscribe handler to a durable JetStream consumer instead of a core NATS subscription.
+// Messages are acknowledged depending on the HTTP status of the dispatched request: 2xx acks the message, a status
+// in TermStatus terminates it, and everything else naks it for redelivery after the backoff.
+//
+// The consumer is created if it does not exist yet; an existing consumer is used as it is. It is never deleted by
+// the bridge, so no messages are lost while Caddy is stopped.
+type JetStreamConsumer struct {
+	Stream  string `json:"stream,omitempty"`
+	Durable string `json:"durable,omitempty"`
+	// Mode is either "pull" (default) or "push". The queue group of the handler is used as deliver group of push
+	// consumers; pull consumers distribute the messages between all Caddy instances anyway.
+	Mode string `json:"mode,omitempty"`
+	// AckWait is the time the HTTP request may take before the message is redelivered. Defaults to 30s.
+	AckWait time.Duration `json:"ack_wait,omitempty"`
+	// MaxDeliver is the maximum number of deliveries of a message; the last failed delivery terminates it.
+	// Unlimited if not set.
+	MaxDeliver int `json:"max_deliver,omitempty"`
+	// Backoff are the delays of the redeliveries; the last one is used for all further redeliveries.
+	Backoff []time.Duration `json:"backoff,omitempty"`
+	// TermStatus are the HTTP status codes for which the message is terminated instead of redelivered, f.e. 400.
+	TermStatus []int `json:"term_status,omitempty"`
+	// FetchBatch is the number of messages a pull consumer fetches at once. Defaults to the concurrency of the
+	// handler.
+	FetchBatch int `json:"fetch_batch,omitempty"`
+}
+
+func (c *JetStreamConsumer) provision(queueGroup string, concurrency int) error {
+	if c.Stream == "" || c.Durable == "" {
+		return fmt.Errorf("jetstream: stream and durable must be specified")
+	}
+	switch c.Mode {
+	case "":
+		c.Mode = ConsumerModePull
+	case ConsumerModePull, ConsumerModePush:
+	default:
+		return fmt.Errorf("jetstream: unknown mode %s, must be one of: %s, %s", c.Mode, ConsumerModePull, ConsumerModePush)
+	}
+	if c.Mode == ConsumerModePull && queueGroup != "" {
+		return fmt.Errorf("jetstream: queue groups are only supported for push consumers")
+	}
+	if c.MaxDeliver > 0 && len(c.Backoff) >= c.MaxDeliver {
+		return fmt.Errorf("jetstream: max_deliver must be greater than the number of backoff delays")
+	}
+	if c.FetchBatch <= 0 {
+		c.FetchBatch = max(concurrency, 1)
+	}
+	return nil
+}
+
+// ensureConsumer creates the durable consumer, unless it exists already.
+func (c *JetStreamConsumer) ensureConsumer(js nats.JetStreamContext, subject string, queueGroup string) error {
+	_, err := js.ConsumerInfo(c.Stream, c.Durable)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("could not load consumer %s of stream %s: %w", c.Durable, c.Stream, err)
+	}
+
+	cfg := &nats.ConsumerConfig{
+		Durable:       c.Durable,
+		FilterSubject: subject,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       c.AckWait,
+		MaxDeliver:    c.MaxDeliver,
+		BackOff:       c.Backoff,
+	}
+	if c.Mode == ConsumerModePush {
+		cfg.DeliverSubject = nats.NewInbox()
+		cfg.DeliverGroup = queueGroup
+	}
+	if _, err := js.AddConsumer(c.Stream, cfg); err != nil {
+		return fmt.Errorf("could not create consumer %s of stream %s: %w", c.Durable, c.Stream, err)
+	}
+	return nil
+}
+
+// subscribe binds to the durable consumer. Push consumers deliver to cb; pull consumers need to be fetched from.
+func (c *JetStreamConsumer) subscribe(js nats.JetStreamContext, subject string, queueGroup string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	if err := c.ensureConsumer(js, subject, queueGroup); err != nil {
+		return nil, err
+	}
+	// binding ensures the library does not delete the consumer on drain.
+	opts := []nats.SubOpt{nats.Bind(c.Stream, c.Durable), nats.ManualAck()}
+	if c.Mode == ConsumerModePull {
+		return js.PullSubscribe(subject, c.Durable, opts...)
+	}
+	if queueGroup != "" {
+		return js.QueueSubscribe(subject, queueGroup, cb, opts...)
+	}
+	return js.Subscribe(subject, cb, opts...)
+}
+
+// backoff returns the delay before the next delivery of a message which was delivered the given number of times.
+func (c *JetStreamConsumer) backoff(delivered uint64) time.Duration {
+	if len(c.Backoff) == 0 {
+		return 0
+	}
+	i := min(int(delivered), len(c.Backoff)) - 1
+	return c.Backoff[max(i, 0)]
+}
+
+// acknowledge acks, naks or terminates a JetStream message depending on the HTTP status it was dispatched with.
+// Messages which can never succeed are terminated regardless of TermStatus, so they are not redelivered forever.
+// Terminated messages are dead-lettered, if configured.
+func (s *Subscribe) acknowledge(msg *nats.Msg, repl *caddy.Replacer, res *dispatchResult) {
+	c := s.JetStream
+	res.attempts = 1
+	if meta, err := msg.Metadata(); err == nil {
+		res.attempts = int(meta.NumDelivered)
+	}
+	fields := []zap.Field{
+		zap.String("subject", msg.Subject),
+		zap.Int("status", res.status),
+		zap.Int("delivered", res.attempts),
+	}
+
+	var err error
+	switch {
+	case res.status >= 200 && res.status <= 299:
+		err = msg.Ack()
+	case slices.Contains(c.TermStatus, res.status), res.permanent():
+		s.logger.Warn("terminating JetStream message", fields...)
+		err = s.term(msg, repl, res)
+	case s.maxDeliveries() > 0 && res.attempts >= s.maxDeliveries():
+		s.logger.Error("giving up on JetStream message after max deliveries", fields...)
+		err = s.term(msg, repl, res)
+	default:
+		delay := c.backoff(uint64(res.attempts))
+		s.logger.Warn("JetStream message not processed, redelivering", append(fields, zap.Duration("delay", delay))...)
+		if delay > 0 {
+			err = msg.NakWithDelay(delay)
+		} else {
+			err = msg.Nak()
+		}
+	}
+	if err != nil {
+		s.logger.Error("could not acknowledge JetStream message", append(fields, zap.Error(err))...)
+	}
+}
+
+// term terminates the message after dead-lettering it. If dead-lettering fails, the message is redelivered instead,
+// so it is not lost.
+func (s *Subscribe) term(msg *nats.Msg, repl *caddy.Replacer, res *dispatchResult) error {
+	if s.DeadLetter != nil && !s.deadLetter(msg, repl, res) {
+		return msg.NakWithDelay(s.JetStream.backoff(uint64(res.attempts)))
+	}
+	return msg.Term()
+}
+
+// maxDeliveries returns the number of deliveries after which a message is given up on; 0 means unlimited.
+func (s *Subscribe) maxDeliveries() int {
+	if s.DeadLetter != nil && s.DeadLetter.Attempts > 0 {
+		return s.DeadLetter.Attempts
+	}
+	return s.JetStream.MaxDeliver
+}
+
+// fetch pulls messages of a pull consumer until ctx is done, and hands them to the current handler.
+func (ps *pooledSubscription) fetch(ctx context.Context, batch int) {
+	defer close(ps.fetchDone)
+	for ctx.Err() == nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchWait)
+		msgs, err := ps.sub.Fetch(batch, nats.Context(fetchCtx))
+		cancel()
+		for _, msg := range msgs {
+			ps.handle(msg)
+		}
+		switch {
+		case err == nil, errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout), ctx.Err() != nil:
+		case errors.Is(err, nats.ErrBadSubscription), errors.Is(err, nats.ErrConnectionClosed):
+			return
+		default:
+			// f.e. while reconnecting; do not retry in a busy loop.
+			ps.target.Load().logger.Warn("could not fetch JetStream messages", zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package subscribe
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func runJetStreamServer(t *testing.T, configure ...func(opts *server.Options)) (*nats.Conn, nats.JetStreamContext) {
+	t.Helper()
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	for _, c := range configure {
+		c(&opts)
+	}
+	srv := natsserver.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return nc, js
+}
+
+func TestJetStreamConsumerProvision(t *testing.T) {
+	tests := []struct {
+		name        string
+		consumer    JetStreamConsumer
+		queueGroup  string
+		concurrency int
+		expectMode  string
+		expectBatch int
+		expectError bool
+	}{
+		{
+			name:        "defaults",
+			consumer:    JetStreamConsumer{Stream: "ORDERS", Durable: "bridge"},
+			expectMode:  ConsumerModePull,
+			expectBatch: 1,
+		},
+		{
+			name:        "batch follows concurrency",
+			consumer:    JetStreamConsumer{Stream: "ORDERS", Durable: "bridge"},
+			concurrency: 8,
+			expectMode:  ConsumerModePull,
+			expectBatch: 8,
+		},
+		{
+			name:        "push consumer with queue group",
+			consumer:    JetStreamConsumer{Stream: "ORDERS", Durable: "bridge", Mode: ConsumerModePush},
+			queueGroup:  "workers",
+			expectMode:  ConsumerModePush,
+			expectBatch: 1,
+		},
+		{
+			name:        "missing durable",
+			consumer:    JetStreamConsumer{Stream: "ORDERS"},
+			expectError: true,
+		},
+		{
+			name:        "unknown mode",
+			consumer:    JetStreamConsumer{Stream: "ORDERS", Durable: "bridge", Mode: "ordered"},
+			expectError: true,
+		},
+		{
+			name:        "pull consumer with queue group",
+			consumer:    JetStreamConsumer{Stream: "ORDERS", Durable: "bridge"},
+			queueGroup:  "workers",
+			expectError: true,
+		},
+		{
+			name: "more backoff delays than deliveries",
+			consumer: JetStreamConsumer{Stream: "ORDERS", Durable: "bridge", MaxDeliver: 2,
+				Backoff: []time.Duration{time.Second, 2 * time.Second}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.consumer.provision(tt.queueGroup, tt.concurrency)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if tt.consumer.Mode != tt.expectMode {
+				t.Errorf("expected mode %s, got %s", tt.expectMode, tt.consumer.Mode)
+			}
+			if tt.consumer.FetchBatch != tt.expectBatch {
+				t.Errorf("expected fetch batch %d, got %d", tt.expectBatch, tt.consumer.FetchBatch)
+			}
+		})
+	}
+}
+
+func TestJetStreamConsumerBackoff(t *testing.T) {
+	c := JetStreamConsumer{Backoff: []time.Duration{time.Second, 5 * time.Second}}
+	expected := map[uint64]time.Duration{0: time.Second, 1: time.Second, 2: 5 * time.Second, 3: 5 * time.Second}
+	for delivered, delay := range expected {
+		if got := c.backoff(delivered); got != delay {
+			t.Errorf("expected delay %s after %d deliveries, got %s", delay, delivered, got)
+		}
+	}
+	if got := (&JetStreamConsumer{}).backoff(3); got != 0 {
+		t.Errorf("expected no delay without backoff, got %s", got)
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+	_, err := js.AddStream(&nats.StreamConfig{Name: "DLQ", Subjects: []string{"dlq.>"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &Subscribe{
+		MetricsSubject: "orders",
+		JetStream: &JetStreamConsumer{
+			Stream:     "ORDERS",
+			Durable:    "acks",
+			Mode:       ConsumerModePush,
+			MaxDeliver: 3,
+			TermStatus: []int{400},
+		},
+		DeadLetter: &DeadLetter{Subject: "dlq.{nats.request.subject}", JetStream: true},
+		conn:       nc,
+		defaults: common.ServerDefaults{
+			JetStream: func() (nats.JetStreamContext, error) { return js, nil },
+		},
+		serverAlias: "default",
+		logger:      zap.NewNop(),
+		metrics:     metrics,
+	}
+	if err := s.JetStream.provision("", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.DeadLetter.provision(s.JetStream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var mu sync.Mutex
+	deliveries := map[string]int{}
+	// the payload is the HTTP status the message is acknowledged with.
+	sub, err := s.JetStream.subscribe(js, "orders.>", "", func(msg *nats.Msg) {
+		mu.Lock()
+		deliveries[string(msg.Data)]++
+		mu.Unlock()
+		status, _ := strconv.Atoi(string(msg.Data))
+		repl := caddy.NewReplacer()
+		common.AddNatsSubscribeVarsToReplacer(repl, msg)
+		s.acknowledge(msg, repl, &dispatchResult{status: status, route: "POST http://localhost/orders"})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	for _, status := range []string{"200", "400", "500"} {
+		if _, err := js.Publish("orders.created", []byte(status)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := map[string]int{"200": 1, "400": 1, "500": 3}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := js.ConsumerInfo("ORDERS", "acks")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mu.Lock()
+		done := info.NumAckPending == 0 && info.NumPending == 0 && deliveries["500"] == expected["500"]
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("messages not acknowledged in time: %+v", info)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for status, count := range expected {
+		if deliveries[status] != count {
+			t.Errorf("expected %d deliveries of the message acknowledged with %s, got %d", count, status, deliveries[status])
+		}
+	}
+
+	// the terminated messages are dead-lettered, with the number of deliveries as attempts.
+	expectedAttempts := map[string]string{"400": "1", "500": "3"}
+	for seq := uint64(1); seq <= 2; seq++ {
+		dead, err := js.GetMsg("DLQ", seq)
+		if err != nil {
+			t.Fatalf("expected dead-lettered message %d, but got: %v", seq, err)
+		}
+		if dead.Subject != "dlq.orders.created" {
+			t.Errorf("expected subject dlq.orders.created, got %s", dead.Subject)
+		}
+		status := dead.Header.Get(DeadLetterStatusHeader)
+		if attempts := dead.Header.Get(DeadLetterAttemptsHeader); attempts != expectedAttempts[status] {
+			t.Errorf("expected %s attempts for status %s, got %s", expectedAttempts[status], status, attempts)
+		}
+		delete(expectedAttempts, status)
+	}
+	if len(expectedAttempts) != 0 {
+		t.Errorf("expected dead-lettered messages for %v", expectedAttempts)
+	}
+}
+
+func TestAcknowledgeFetchError(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+	_, err := js.AddStream(&nats.StreamConfig{Name: "DLQ", Subjects: []string{"dlq.>"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "bodies"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// unlimited deliveries and no term_status: only the fetch errors terminate the messages.
+	s := &Subscribe{
+		Method:         "POST",
+		URL:            "http://localhost/orders",
+		MetricsSubject: "orders",
+		JetStream:      &JetStreamConsumer{Stream: "ORDERS", Durable: "fetch", Mode: ConsumerModePush},
+		DeadLetter:     &DeadLetter{Subject: "dlq.{nats.request.subject}", JetStream: true},
+		FetchBody:      &common.FetchBody{Buckets: []string{"bodies"}},
+		conn:           nc,
+		defaults: common.ServerDefaults{
+			JetStream: func() (nats.JetStreamContext, error) { return js, nil },
+		},
+		serverAlias: "default",
+		logger:      zap.NewNop(),
+		metrics:     metrics,
+	}
+	if err := s.JetStream.provision("", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var mu sync.Mutex
+	deliveries := map[string]int{}
+	sub, err := s.JetStream.subscribe(js, "orders.>", "", func(msg *nats.Msg) {
+		mu.Lock()
+		deliveries[msg.Header.Get(common.BodyBucketHeader)]++
+		mu.Unlock()
+		s.handler(msg)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	// a bucket which is not allowed (403), and an object which does not exist (410).
+	for _, bucket := range []string{"other", "bodies"} {
+		msg := nats.NewMsg("orders.created")
+		msg.Header.Set(common.BodyBucketHeader, bucket)
+		msg.Header.Set(common.BodyIdHeader, "expired")
+		if _, err := js.PublishMsg(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := js.ConsumerInfo("ORDERS", "fetch")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.NumAckPending == 0 && info.NumPending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("messages not acknowledged in time: %+v", info)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, bucket := range []string{"other", "bodies"} {
+		if deliveries[bucket] != 1 {
+			t.Errorf("expected the message referencing bucket %s to be delivered once, got %d", bucket, deliveries[bucket])
+		}
+	}
+
+	expectedStatus := map[string]bool{"403": true, "410": true}
+	for seq := uint64(1); seq <= 2; seq++ {
+		dead, err := js.GetMsg("DLQ", seq)
+		if err != nil {
+			t.Fatalf("expected dead-lettered message %d, but got: %v", seq, err)
+		}
+		delete(expectedStatus, dead.Header.Get(DeadLetterStatusHeader))
+	}
+	if len(expectedStatus) != 0 {
+		t.Errorf("expected dead-lettered messages with status %v", expectedStatus)
+	}
+}
+
+func TestPullConsumerSurvivesDrain(t *testing.T) {
+	_, js := runJetStreamServer(t)
+
+	s := &Subscribe{
+		logger:    zap.NewNop(),
+		JetStream: &JetStreamConsumer{Stream: "ORDERS", Durable: "durable"},
+	}
+	if err := s.JetStream.provision("", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ps := &pooledSubscription{}
+	ps.addTarget(s)
+	var err error
+	ps.sub, err = s.JetStream.subscribe(js, "orders.>", "", ps.handle)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ps.startFetching(s.JetStream.FetchBatch)
+
+	if err := ps.Destruct(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-ps.sub.StatusChanged(nats.SubscriptionClosed):
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscription not drained in time")
+	}
+
+	if _, err := js.ConsumerInfo("ORDERS", "durable"); err != nil {
+		t.Errorf("expected the durable consumer to survive the drain, but got: %v", err)
+	}
+}
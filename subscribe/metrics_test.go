@@ -0,0 +1,93 @@
+package subscribe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func TestSubscribeMetrics(t *testing.T) {
+	srv := natsserver.RunRandClientPortServer()
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &Subscribe{
+		Subject:        "orders.>",
+		Method:         "POST",
+		URL:            "http://127.0.0.1:9999/orders",
+		MetricsSubject: "orders",
+		subject:        "orders.>",
+		conn:           nc,
+		serverAlias:    "default",
+		logger:         zap.NewNop(),
+		metrics:        metrics,
+		inFlight:       &common.InFlight{},
+		// no server matches, so the request is answered with an error reply.
+		httpApp: &caddyhttp.App{Servers: map[string]*caddyhttp.Server{}},
+	}
+	sub, err := nc.Subscribe(s.subject, s.dispatch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	resp, err := nc.Request("orders.created", []byte("payload"), 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected a reply, but got: %v", err)
+	}
+	if code := resp.Header.Get("Nats-Service-Error-Code"); code != "404" {
+		t.Errorf("expected Nats-Service-Error-Code 404, got %s", code)
+	}
+	// the reply is sent before the handler returns and records its duration.
+	s.inFlight.Wait(context.Background())
+
+	messages := &dto.Metric{}
+	if err := metrics.SubscribeMessages.WithLabelValues("default", "orders").Write(messages); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if messages.GetCounter().GetValue() != 1 {
+		t.Errorf("expected 1 received message, got %v", messages.GetCounter().GetValue())
+	}
+	inFlight := &dto.Metric{}
+	if err := metrics.SubscribeInFlight.WithLabelValues("default", "orders").Write(inFlight); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inFlight.GetGauge().GetValue() != 0 {
+		t.Errorf("expected no in-flight messages, got %v", inFlight.GetGauge().GetValue())
+	}
+
+	duration := &dto.Metric{}
+	if err := metrics.SubscribeDuration.WithLabelValues("default", "orders").(prometheus.Metric).Write(duration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if duration.GetHistogram().GetSampleCount() != 1 {
+		t.Errorf("expected 1 observed dispatch duration, got %d", duration.GetHistogram().GetSampleCount())
+	}
+	size := &dto.Metric{}
+	if err := metrics.PayloadSize.WithLabelValues("default", "orders", "subscribe", common.PayloadDirectionIn).(prometheus.Metric).Write(size); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size.GetHistogram().GetSampleCount() != 1 || size.GetHistogram().GetSampleSum() != 7 {
+		t.Errorf("expected one payload of 7 bytes, got %d with %v bytes", size.GetHistogram().GetSampleCount(), size.GetHistogram().GetSampleSum())
+	}
+}
@@ -0,0 +1,118 @@
+package subscribe
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"go.uber.org/zap"
+)
+
+const DefaultResponseBucket = "LargeHttpResponseBodies"
+
+// OffloadResponse stores HTTP responses which are too large for a NATS reply in a JetStream object store. The reply
+// then has an empty body, and references the stored body with the same headers as store_body_to_jetstream.
+type OffloadResponse struct {
+	// Bucket is the object store the responses are stored in; it is created if it does not exist.
+	Bucket string `json:"bucket,omitempty"`
+	// TTL is the time the responses are kept; an existing bucket must have the same TTL. Defaults to 5m.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Threshold is the reply size (in bytes) above which the body is offloaded. Defaults to the max payload of the
+	// NATS server.
+	Threshold int64 `json:"threshold,omitempty"`
+
+	// do not use directly, but always use Subscribe.responseStore() to access, to ensure it is initialized.
+	store atomic.Pointer[nats.ObjectStore]
+}
+
+func (o *OffloadResponse) provision() error {
+	if o.Bucket == "" {
+		o.Bucket = DefaultResponseBucket
+	}
+	if o.TTL == 0 {
+		o.TTL = 5 * time.Minute
+	}
+	if o.Threshold < 0 {
+		return fmt.Errorf("offload_response: threshold must not be negative")
+	}
+	return nil
+}
+
+// msgSize returns the size of the message on the wire, excluding the subject.
+func msgSize(msg *nats.Msg) int64 {
+	size := len(msg.Data)
+	if len(msg.Header) > 0 {
+		// NATS/1.0\r\n, one line per header value, and the final \r\n.
+		size += len("NATS/1.0\r\n\r\n")
+		for k, values := range msg.Header {
+			for _, v := range values {
+				size += len(k) + len(": \r\n") + len(v)
+			}
+		}
+	}
+	return int64(size)
+}
+
+// offloadReply moves the body of a too large reply to the object store.
+func (s *Subscribe) offloadReply(reply *nats.Msg) error {
+	o := s.OffloadResponse
+	threshold := o.Threshold
+	if threshold == 0 {
+		threshold = s.conn.MaxPayload()
+	}
+	if len(reply.Data) == 0 || msgSize(reply) <= threshold {
+		return nil
+	}
+
+	store, err := s.responseStore()
+	if err != nil {
+		return err
+	}
+	id := nuid.Next()
+	meta := &nats.ObjectMeta{Name: id}
+	if contentType := reply.Header.Get("Content-Type"); contentType != "" {
+		// so the body can be served without the reply, f.e. by nats_request.
+		meta.Headers = nats.Header{"Content-Type": []string{contentType}}
+	}
+	if _, err := store.Put(meta, bytes.NewReader(reply.Data)); err != nil {
+		return fmt.Errorf("cannot store response to object store %s: %w", o.Bucket, err)
+	}
+	s.metrics.BodiesStored.WithLabelValues(s.serverAlias, o.Bucket).Inc()
+	s.metrics.PayloadSize.WithLabelValues(s.serverAlias, o.Bucket, "subscribe_offload_response", common.PayloadDirectionOut).Observe(float64(len(reply.Data)))
+	s.logger.Debug("offloaded NATS reply body to object store",
+		zap.String("bucket", o.Bucket),
+		zap.String("id", id),
+		zap.Int("size", len(reply.Data)))
+
+	reply.Data = nil
+	reply.Header.Del("Content-Length")
+	reply.Header.Set(common.BodyBucketHeader, o.Bucket)
+	reply.Header.Set(common.BodyIdHeader, id)
+	return nil
+}
+
+// responseStore returns the object store for offloaded responses. It is loaded (and created, if it does not exist
+// yet) on first use, and re-used for all further responses.
+func (s *Subscribe) responseStore() (nats.ObjectStore, error) {
+	o := s.OffloadResponse
+	if store := o.store.Load(); store != nil {
+		return *store, nil
+	}
+	if s.defaults.JetStream == nil {
+		return nil, fmt.Errorf("JetStream is not available for server %s", s.serverAlias)
+	}
+	js, err := s.defaults.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	store, err := common.LoadOrCreateObjectStore(js, o.Bucket, o.TTL, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	o.store.Store(&store)
+	return store, nil
+}
@@ -0,0 +1,131 @@
+package subscribe
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CoverWhale/caddy-nats-bridge/common"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestOffloadResponse(t *testing.T) {
+	nc, js := runJetStreamServer(t, func(opts *server.Options) {
+		opts.MaxPayload = 256 * 1024
+	})
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	small := []byte("small response")
+	large := bytes.Repeat([]byte("x"), 300*1024)
+
+	tests := []struct {
+		name            string
+		offload         *OffloadResponse
+		data            []byte
+		expectOffloaded bool
+		expectStatus    string
+	}{
+		{
+			name:    "small response",
+			offload: &OffloadResponse{},
+			data:    small,
+		},
+		{
+			name:            "response above max payload",
+			offload:         &OffloadResponse{},
+			data:            large,
+			expectOffloaded: true,
+		},
+		{
+			name:            "response above threshold",
+			offload:         &OffloadResponse{Threshold: 10},
+			data:            small,
+			expectOffloaded: true,
+		},
+		{
+			name:         "response above max payload without offloading",
+			data:         large,
+			expectStatus: "502",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Subscribe{
+				OffloadResponse: tt.offload,
+				conn:            nc,
+				defaults: common.ServerDefaults{
+					JetStream: func() (nats.JetStreamContext, error) { return js, nil },
+				},
+				serverAlias: "default",
+				logger:      zap.NewNop(),
+				metrics:     metrics,
+			}
+			if s.OffloadResponse != nil {
+				if err := s.OffloadResponse.provision(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			sub, err := nc.Subscribe("reports.daily", func(msg *nats.Msg) {
+				reply := nats.NewMsg("")
+				reply.Data = tt.data
+				reply.Header.Set("X-NatsBridge-Status", "200")
+				s.respond(msg, reply)
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer sub.Unsubscribe()
+
+			resp, err := nc.Request("reports.daily", nil, 5*time.Second)
+			if err != nil {
+				t.Fatalf("expected a reply, but got: %v", err)
+			}
+			if tt.expectStatus != "" {
+				if code := resp.Header.Get("Nats-Service-Error-Code"); code != tt.expectStatus {
+					t.Errorf("expected Nats-Service-Error-Code %s, got %s", tt.expectStatus, code)
+				}
+				return
+			}
+
+			bucket, id := resp.Header.Get(common.BodyBucketHeader), resp.Header.Get(common.BodyIdHeader)
+			if !tt.expectOffloaded {
+				if bucket != "" || !bytes.Equal(resp.Data, tt.data) {
+					t.Errorf("expected the body in the reply, got %q (bucket %q)", resp.Data, bucket)
+				}
+				return
+			}
+			if len(resp.Data) != 0 {
+				t.Errorf("expected an empty reply, got %d bytes", len(resp.Data))
+			}
+			if bucket != DefaultResponseBucket {
+				t.Errorf("expected bucket %s, got %q", DefaultResponseBucket, bucket)
+			}
+			store, err := js.ObjectStore(bucket)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, err := store.GetBytes(id)
+			if err != nil {
+				t.Fatalf("expected the offloaded body, but got: %v", err)
+			}
+			if !bytes.Equal(body, tt.data) {
+				t.Errorf("expected the offloaded body to match the response")
+			}
+			if s.OffloadResponse.store.Load() == nil {
+				t.Errorf("expected the object store to be cached for further responses")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package subscribe
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestSubscribeProvision(t *testing.T) {
+	emptyPrefix := ""
+	invalidPrefix := "tenant..a."
+
+	tests := []struct {
+		name        string
+		subscribe   Subscribe
+		expectError bool
+	}{
+		{
+			name:      "wildcard subject",
+			subscribe: Subscribe{Subject: "orders.*.>"},
+		},
+		{
+			name:      "disabled subject prefix",
+			subscribe: Subscribe{Subject: "orders.>", SubjectPrefix: &emptyPrefix},
+		},
+		{
+			name:        "empty subject",
+			subscribe:   Subscribe{},
+			expectError: true,
+		},
+		{
+			name:        "full wildcard in the middle",
+			subscribe:   Subscribe{Subject: "orders.>.created"},
+			expectError: true,
+		},
+		{
+			name:        "whitespace in subject",
+			subscribe:   Subscribe{Subject: "orders created"},
+			expectError: true,
+		},
+		{
+			name:        "invalid subject prefix",
+			subscribe:   Subscribe{Subject: "orders.>", SubjectPrefix: &invalidPrefix},
+			expectError: true,
+		},
+		{
+			name:        "unknown saturation policy",
+			subscribe:   Subscribe{Subject: "orders.>", SaturationPolicy: "ignore"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			err := tt.subscribe.Provision(ctx)
+			if tt.expectError && err == nil {
+				t.Errorf("expected an error, but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error, but got: %v", err)
+			}
+		})
+	}
+}
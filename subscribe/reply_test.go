@@ -0,0 +1,71 @@
+package subscribe
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestReplyMsg(t *testing.T) {
+	tests := []struct {
+		status            int
+		expectedErrorCode string
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusCreated},
+		{status: http.StatusNotFound, expectedErrorCode: "404"},
+		{status: http.StatusInternalServerError, expectedErrorCode: "500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Resp-Header", "RespHeaderValue")
+			rec.WriteHeader(tt.status)
+			_, _ = rec.Write([]byte("resp"))
+
+			msg := replyMsg(rec)
+			if string(msg.Data) != "resp" {
+				t.Errorf("expected payload resp, got %s", msg.Data)
+			}
+			if h := msg.Header.Get("Resp-Header"); h != "RespHeaderValue" {
+				t.Errorf("expected Resp-Header to be kept, got %s", h)
+			}
+			if h := msg.Header.Get("X-NatsBridge-Status"); h != strconv.Itoa(tt.status) {
+				t.Errorf("expected X-NatsBridge-Status %d, got %s", tt.status, h)
+			}
+			if h := msg.Header.Get("Nats-Service-Error-Code"); h != tt.expectedErrorCode {
+				t.Errorf("expected Nats-Service-Error-Code %q, got %q", tt.expectedErrorCode, h)
+			}
+			if tt.expectedErrorCode != "" && msg.Header.Get("Nats-Service-Error") != http.StatusText(tt.status) {
+				t.Errorf("expected Nats-Service-Error %q, got %q", http.StatusText(tt.status), msg.Header.Get("Nats-Service-Error"))
+			}
+		})
+	}
+}
+
+func TestErrorReplyMsg(t *testing.T) {
+	msg := errorReplyMsg(http.StatusNotFound, "no server matched", errors.New("no server matched for the current url: http://localhost:9999/"))
+
+	if h := msg.Header.Get("Nats-Service-Error-Code"); h != "404" {
+		t.Errorf("expected Nats-Service-Error-Code 404, got %s", h)
+	}
+	if h := msg.Header.Get("Nats-Service-Error"); h != "no server matched" {
+		t.Errorf("expected Nats-Service-Error %q, got %q", "no server matched", h)
+	}
+	if h := msg.Header.Get("X-NatsBridge-Status"); h != "404" {
+		t.Errorf("expected X-NatsBridge-Status 404, got %s", h)
+	}
+
+	var body dispatchError
+	if err := json.Unmarshal(msg.Data, &body); err != nil {
+		t.Fatalf("expected a JSON body, but got: %v", err)
+	}
+	expected := dispatchError{Error: "no server matched", Detail: "no server matched for the current url: http://localhost:9999/"}
+	if body != expected {
+		t.Errorf("expected body %+v, got %+v", expected, body)
+	}
+}
@@ -1,12 +1,16 @@
 package subscribe
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/CoverWhale/caddy-nats-bridge/common"
 	"github.com/caddyserver/caddy/v2"
@@ -20,14 +24,57 @@ type Subscribe struct {
 	Method     string `json:"method,omitempty"`
 	URL        string `json:"path,omitempty"`
 	QueueGroup string `json:"queue_group,omitempty"`
+	// MetricsSubject is used as subject label for metrics; defaults to Subject.
+	MetricsSubject string `json:"metrics_subject,omitempty"`
+	// SubjectPrefix overrides the subject prefix of the server; an empty string disables it.
+	SubjectPrefix *string `json:"subject_prefix,omitempty"`
+	// Headers are added to every HTTP request, overriding the headers of the server with the same name.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Concurrency is the number of messages which are dispatched to HTTP concurrently. If not set, messages are
+	// dispatched one after another, in order.
+	Concurrency int `json:"concurrency,omitempty"`
+	// SaturationPolicy decides what happens to a message if all Concurrency workers are busy: "block" (default)
+	// waits for a free worker, so messages queue up in the subscription up to the pending limits; "drop" discards
+	// the message; "reply_503" answers requests with a 503 service error and discards published messages.
+	SaturationPolicy string `json:"saturation_policy,omitempty"`
+	// PendingMsgsLimit and PendingBytesLimit limit the messages buffered by the subscription; when they are
+	// exceeded, messages are dropped and a slow consumer error is logged. -1 means unlimited; unset values use the
+	// nats.go defaults.
+	PendingMsgsLimit  int `json:"pending_msgs_limit,omitempty"`
+	PendingBytesLimit int `json:"pending_bytes_limit,omitempty"`
+	// JetStream consumes the messages from a durable JetStream consumer instead of a core NATS subscription.
+	JetStream *JetStreamConsumer `json:"jetstream,omitempty"`
+	// DeadLetter republishes messages which repeatedly fail to be dispatched.
+	DeadLetter *DeadLetter `json:"dead_letter,omitempty"`
+	// FetchBody dispatches bodies offloaded by store_body_to_jetstream instead of the (empty) message data.
+	FetchBody *common.FetchBody `json:"fetch_body,omitempty"`
+	// OffloadResponse stores responses which are too large for a NATS reply in a JetStream object store.
+	OffloadResponse *OffloadResponse `json:"offload_response,omitempty"`
 
-	conn    *nats.Conn
-	sub     *nats.Subscription
-	ctx     caddy.Context
-	logger  *zap.Logger
-	httpApp *caddyhttp.App
+	// subject is the Subject including the subject prefix.
+	subject     string
+	headers     map[string]string
+	defaults    common.ServerDefaults
+	conn        *nats.Conn
+	sub         *nats.Subscription
+	pooled      *pooledSubscription
+	poolKey     string
+	ctx         caddy.Context
+	logger      *zap.Logger
+	httpApp     *caddyhttp.App
+	metrics     *common.Metrics
+	serverAlias string
+	inFlight    *common.InFlight
+	// workers limits the concurrently dispatched messages; nil if Concurrency is not set.
+	workers chan struct{}
 }
 
+const (
+	SaturationPolicyBlock    = "block"
+	SaturationPolicyDrop     = "drop"
+	SaturationPolicyReply503 = "reply_503"
+)
+
 func (Subscribe) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID:  "nats.handlers.subscribe",
@@ -38,14 +85,63 @@ func (Subscribe) CaddyModule() caddy.ModuleInfo {
 func (s *Subscribe) Provision(ctx caddy.Context) error {
 	s.ctx = ctx
 	s.logger = ctx.Logger()
+	s.inFlight = &common.InFlight{}
+
+	if err := common.ValidateSubscribeSubject(s.Subject); err != nil {
+		return err
+	}
+	if s.SubjectPrefix != nil {
+		if err := common.ValidateSubjectPrefix(*s.SubjectPrefix); err != nil {
+			return err
+		}
+	}
+	switch s.SaturationPolicy {
+	case "", SaturationPolicyBlock, SaturationPolicyDrop, SaturationPolicyReply503:
+	default:
+		return fmt.Errorf("unknown saturation_policy %s, must be one of: %s, %s, %s", s.SaturationPolicy, SaturationPolicyBlock, SaturationPolicyDrop, SaturationPolicyReply503)
+	}
+	if s.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if s.Concurrency > 0 {
+		s.workers = make(chan struct{}, s.Concurrency)
+	}
+	if s.JetStream != nil {
+		if err := s.JetStream.provision(s.QueueGroup, s.Concurrency); err != nil {
+			return err
+		}
+	}
+	if s.DeadLetter != nil {
+		if err := s.DeadLetter.provision(s.JetStream); err != nil {
+			return err
+		}
+	}
+	if s.OffloadResponse != nil {
+		if err := s.OffloadResponse.provision(); err != nil {
+			return err
+		}
+	}
+
+	metrics, err := common.GetMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+	s.metrics = metrics
+	if s.MetricsSubject == "" {
+		s.MetricsSubject = s.Subject
+	}
 
 	return nil
 }
 
-func (s *Subscribe) Subscribe(conn *nats.Conn) error {
+func (s *Subscribe) Subscribe(serverAlias string, defaults common.ServerDefaults, conn *nats.Conn) error {
+	s.subject = defaults.Subject(s.Subject, s.SubjectPrefix)
+	s.headers = defaults.MergedHeaders(s.Headers)
+	s.defaults = defaults
+
 	s.logger.Info(
 		"subscribing to NATS subject",
-		zap.String("subject", s.Subject),
+		zap.String("subject", s.subject),
 		zap.String("queue_group", s.QueueGroup),
 		zap.String("method", s.Method),
 		zap.String("url", s.URL),
@@ -57,29 +153,234 @@ func (s *Subscribe) Subscribe(conn *nats.Conn) error {
 	}
 	s.httpApp = httpAppIface.(*caddyhttp.App)
 	s.conn = conn
+	s.serverAlias = serverAlias
 
-	if s.QueueGroup != "" {
-		s.sub, err = conn.QueueSubscribe(s.Subject, s.QueueGroup, s.handler)
-	} else {
-		s.sub, err = conn.Subscribe(s.Subject, s.handler)
+	key, err := s.subscriptionKey()
+	if err != nil {
+		return err
+	}
+	val, loaded, err := subPool.LoadOrNew(key, func() (caddy.Destructor, error) {
+		ps := &pooledSubscription{}
+		ps.addTarget(s)
+		var err error
+		if s.JetStream != nil {
+			if err := s.subscribeJetStream(ps, defaults); err != nil {
+				return nil, err
+			}
+			return ps, nil
+		}
+		if s.QueueGroup != "" {
+			ps.sub, err = conn.QueueSubscribe(s.subject, s.QueueGroup, ps.handle)
+		} else {
+			ps.sub, err = conn.Subscribe(s.subject, ps.handle)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ps, s.setPendingLimits(ps.sub)
+	})
+	if err != nil {
+		return err
 	}
 
-	return err
+	// from now on, all messages of the (possibly re-used) subscription are handled by this handler.
+	ps := val.(*pooledSubscription)
+	if loaded {
+		ps.addTarget(s)
+		s.logger.Info("re-using NATS subscription of previous config", zap.String("subject", s.subject))
+	}
+	s.pooled = ps
+	s.sub = ps.sub
+	s.poolKey = key
+
+	return nil
 }
 
-func (s *Subscribe) Unsubscribe(conn *nats.Conn) error {
+func (s *Subscribe) Unsubscribe(ctx context.Context, conn *nats.Conn) error {
 	s.logger.Info(
 		"unsubscribing from NATS subject",
-		zap.String("subject", s.Subject),
+		zap.String("subject", s.subject),
 		zap.String("queue_group", s.QueueGroup),
 		zap.String("method", s.Method),
 		zap.String("url", s.URL),
 	)
 
-	return s.sub.Drain()
+	if s.poolKey == "" {
+		// never subscribed, f.e. because the connection was not established yet (async connect mode).
+		return nil
+	}
+
+	// the subscription is only drained if no other config uses it anymore.
+	s.pooled.removeTarget(s)
+	deleted, err := subPool.Delete(s.poolKey)
+	s.poolKey = ""
+	if err != nil {
+		return err
+	}
+
+	if deleted {
+		// draining is complete once all pending messages are handled.
+		select {
+		case <-s.sub.StatusChanged(nats.SubscriptionClosed):
+		case <-ctx.Done():
+			pendingMsgs, _, _ := s.sub.Pending()
+			s.logger.Warn("NATS subscription not drained in time, abandoning pending messages",
+				zap.String("subject", s.subject),
+				zap.Int("pending_msgs", pendingMsgs))
+		}
+	}
+	// messages which are currently handled by this config, even if the subscription is re-used by a new config.
+	if abandoned := s.inFlight.Wait(ctx); abandoned > 0 {
+		s.logger.Warn("abandoning in-flight NATS messages",
+			zap.String("subject", s.subject),
+			zap.Int("in_flight", abandoned))
+	}
+
+	return nil
+}
+
+// subscribeJetStream binds the pooled subscription to the durable consumer of the handler.
+func (s *Subscribe) subscribeJetStream(ps *pooledSubscription, defaults common.ServerDefaults) error {
+	if defaults.JetStream == nil {
+		return fmt.Errorf("JetStream is not available for server %s", s.serverAlias)
+	}
+	js, err := defaults.JetStream()
+	if err != nil {
+		return err
+	}
+	ps.sub, err = s.JetStream.subscribe(js, s.subject, s.QueueGroup, ps.handle)
+	if err != nil {
+		return err
+	}
+	if err := s.setPendingLimits(ps.sub); err != nil {
+		return err
+	}
+	if s.JetStream.Mode == ConsumerModePull {
+		ps.startFetching(s.JetStream.FetchBatch)
+	}
+	s.logger.Info("bound to JetStream consumer",
+		zap.String("stream", s.JetStream.Stream),
+		zap.String("durable", s.JetStream.Durable),
+		zap.String("mode", s.JetStream.Mode))
+	return nil
+}
+
+// subscriptionKey identifies a pooled subscription by its connection, its (prefixed) subject and the full handler
+// configuration.
+func (s *Subscribe) subscriptionKey() (string, error) {
+	cfg, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("could not build subscription key: %w", err)
+	}
+	return fmt.Sprintf("%p-%s-%s", s.conn, s.subject, cfg), nil
+}
+
+func (s *Subscribe) SubscriptionInfo() common.SubscriptionInfo {
+	subject := s.subject
+	if subject == "" {
+		subject = s.Subject
+	}
+	info := common.SubscriptionInfo{
+		Subject:    subject,
+		QueueGroup: s.QueueGroup,
+	}
+	if s.sub == nil || !s.sub.IsValid() {
+		return info
+	}
+
+	info.Active = true
+	// the errors can be ignored; they only occur if the subscription was closed in the meantime.
+	info.PendingMsgs, info.PendingBytes, _ = s.sub.Pending()
+	info.Dropped, _ = s.sub.Dropped()
+	info.Delivered, _ = s.sub.Delivered()
+
+	return info
+}
+
+// setPendingLimits applies the configured pending limits to the subscription.
+func (s *Subscribe) setPendingLimits(sub *nats.Subscription) error {
+	if s.PendingMsgsLimit == 0 && s.PendingBytesLimit == 0 {
+		return nil
+	}
+	msgsLimit, bytesLimit := s.PendingMsgsLimit, s.PendingBytesLimit
+	if msgsLimit == 0 {
+		msgsLimit = nats.DefaultSubPendingMsgsLimit
+	}
+	if bytesLimit == 0 {
+		bytesLimit = nats.DefaultSubPendingBytesLimit
+	}
+	if err := sub.SetPendingLimits(msgsLimit, bytesLimit); err != nil {
+		// the subscription is not pooled, so it must be cleaned up here.
+		_ = sub.Unsubscribe()
+		return fmt.Errorf("could not set pending limits: %w", err)
+	}
+	return nil
+}
+
+// dispatch is called serially for all messages of the subscription. Without Concurrency, the message is handled
+// directly; otherwise it is handed to a worker, depending on the SaturationPolicy if all workers are busy.
+func (s *Subscribe) dispatch(msg *nats.Msg) {
+	s.inFlight.Begin()
+	if s.workers == nil {
+		defer s.inFlight.End()
+		s.handler(msg)
+		return
+	}
+
+	select {
+	case s.workers <- struct{}{}:
+	default:
+		switch s.SaturationPolicy {
+		case SaturationPolicyDrop:
+			s.inFlight.End()
+			s.reject(msg)
+			return
+		case SaturationPolicyReply503:
+			s.inFlight.End()
+			s.reject(msg)
+			s.respond(msg, errorReplyMsg(http.StatusServiceUnavailable, "too many concurrent messages",
+				fmt.Errorf("all %d workers of subscription %s are busy", s.Concurrency, s.subject)))
+			return
+		default:
+			// block the subscription until a worker is free.
+			s.workers <- struct{}{}
+		}
+	}
+
+	go func() {
+		defer func() {
+			<-s.workers
+			s.inFlight.End()
+		}()
+		s.handler(msg)
+	}()
+}
+
+func (s *Subscribe) reject(msg *nats.Msg) {
+	if s.JetStream != nil {
+		// JetStream messages are not lost, but redelivered once a worker is free again.
+		if err := msg.Nak(); err != nil {
+			s.logger.Error("could not nak JetStream message", zap.String("subject", msg.Subject), zap.Error(err))
+		}
+	}
+	s.metrics.SubscribeRejected.WithLabelValues(s.serverAlias, s.MetricsSubject, s.SaturationPolicy).Inc()
+	s.logger.Warn("all workers busy, rejecting NATS message",
+		zap.String("subject", msg.Subject),
+		zap.String("saturation_policy", s.SaturationPolicy),
+		zap.Int("concurrency", s.Concurrency))
 }
 
 func (s *Subscribe) handler(msg *nats.Msg) {
+	start := time.Now()
+	s.metrics.SubscribeMessages.WithLabelValues(s.serverAlias, s.MetricsSubject).Inc()
+	s.metrics.PayloadSize.WithLabelValues(s.serverAlias, s.MetricsSubject, "subscribe", common.PayloadDirectionIn).Observe(float64(len(msg.Data)))
+	inFlight := s.metrics.SubscribeInFlight.WithLabelValues(s.serverAlias, s.MetricsSubject)
+	inFlight.Inc()
+	defer func() {
+		inFlight.Dec()
+		s.metrics.SubscribeDuration.WithLabelValues(s.serverAlias, s.MetricsSubject).Observe(time.Since(start).Seconds())
+	}()
+
 	repl := caddy.NewReplacer()
 	common.AddNatsSubscribeVarsToReplacer(repl, msg)
 
@@ -95,34 +396,147 @@ func (s *Subscribe) handler(msg *nats.Msg) {
 		zap.Bool("with_reply", msg.Reply != ""),
 	)
 
-	req, err := s.prepareRequest(method, url, bytes.NewBuffer(msg.Data), msg.Header)
+	res := s.serve(msg, method, url, 1)
+	for s.JetStream == nil && res.failed() && !res.permanent() && res.attempts < s.DeadLetter.maxAttempts() {
+		s.logger.Warn("dispatching NATS message failed, retrying",
+			zap.String("subject", msg.Subject),
+			zap.Int("status", res.status),
+			zap.Int("attempts", res.attempts),
+			zap.Error(res.err))
+		res = s.serve(msg, method, url, res.attempts+1)
+	}
+
+	if s.JetStream != nil {
+		// the reply subject of JetStream messages is used for acknowledgement; the status decides about it.
+		s.acknowledge(msg, repl, res)
+		return
+	}
+	if res.failed() && s.DeadLetter != nil {
+		s.deadLetter(msg, repl, res)
+	}
+	if res.reply != nil {
+		s.respond(msg, res.reply)
+	}
+}
+
+// serve dispatches the message to the matching Caddy server. The response is only recorded if somebody is
+// interested in it.
+func (s *Subscribe) serve(msg *nats.Msg, method string, url string, attempt int) *dispatchResult {
+	res := &dispatchResult{route: method + " " + url, attempts: attempt}
+
+	body, err := s.body(msg)
+	if err != nil {
+		status := http.StatusBadGateway
+		var fetchErr *common.FetchError
+		if errors.As(err, &fetchErr) {
+			status = fetchErr.Status
+		}
+		s.logger.Error("error fetching body", zap.Error(err))
+		res.status, res.err = status, err
+		res.reply = errorReplyMsg(res.status, fetchErrorReason(status), err)
+		return res
+	}
+	defer body.Close()
+
+	req, err := s.prepareRequest(method, url, body, msg.Header)
 	if err != nil {
 		s.logger.Error("error creating request", zap.Error(err))
-		return
+		res.status, res.err = http.StatusBadRequest, err
+		res.reply = errorReplyMsg(res.status, "invalid request", err)
+		return res
+	}
+	req.ContentLength = body.size
+	if body.offloaded != nil {
+		// the HTTP handlers get the body itself, and not the reference to it. The headers are not canonicalized.
+		delete(req.Header, common.BodyBucketHeader)
+		delete(req.Header, common.BodyIdHeader)
 	}
 
 	server, err := s.matchServer(s.httpApp.Servers, req)
 	if err != nil {
 		s.logger.Error("error matching server", zap.Error(err))
-		return
+		res.status, res.err = http.StatusNotFound, err
+		res.reply = errorReplyMsg(res.status, "no server matched", err)
+		return res
+	}
+
+	if msg.Reply == "" && s.JetStream == nil && s.DeadLetter == nil && (body.offloaded == nil || !s.FetchBody.Delete) {
+		// no reply subject was set -> the original NATS requester is not interested in the response - we can ignore it.
+		server.ServeHTTP(common.NoopResponseWriter{}, req)
+		return res
 	}
 
-	if msg.Reply != "" {
-		// the incoming NATS Message has a reply subject set; so it was sent via request() (and not via publish()).
-		// -> so we can send the response back.
-		rec := httptest.NewRecorder()
-		server.ServeHTTP(rec, req)
-		// rec.Code -> TODO: new status code
-		//TODO Handle error
-		msg.RespondMsg(&nats.Msg{
-			Header: nats.Header(rec.Header()),
-			Data:   rec.Body.Bytes(),
-		})
+	// f.e. the incoming NATS Message has a reply subject set; so it was sent via request() (and not via publish()).
+	// -> so we can send the response back.
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	res.status = rec.Code
+	res.reply = replyMsg(rec)
+	s.deleteBody(body, res.status)
+	s.metrics.PayloadSize.WithLabelValues(s.serverAlias, s.MetricsSubject, "subscribe", common.PayloadDirectionOut).Observe(float64(rec.Body.Len()))
+	return res
+}
+
+// respond sends the reply if the message was sent as request; for published messages, nobody is interested in it.
+func (s *Subscribe) respond(msg *nats.Msg, reply *nats.Msg) {
+	if msg.Reply == "" || s.JetStream != nil {
 		return
 	}
+	if s.OffloadResponse != nil {
+		if err := s.offloadReply(reply); err != nil {
+			s.logger.Error("could not offload NATS reply body", zap.String("subject", msg.Subject), zap.Error(err))
+			reply = errorReplyMsg(http.StatusBadGateway, "could not offload response", err)
+		}
+	}
+	err := msg.RespondMsg(reply)
+	if errors.Is(err, nats.ErrMaxPayload) {
+		// let the requester know instead of running into its timeout.
+		err = msg.RespondMsg(errorReplyMsg(http.StatusBadGateway, "response too large", err))
+	}
+	if err != nil {
+		s.logger.Error("could not send NATS reply",
+			zap.String("subject", msg.Subject),
+			zap.String("reply", msg.Reply),
+			zap.Error(err))
+	}
+}
+
+// dispatchError is the body of the reply if a message could not be dispatched to a Caddy server.
+type dispatchError struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail"`
+}
 
-	// no reply subject was set -> the original NATS requester is not interested in the response - we can ignore it.
-	server.ServeHTTP(common.NoopResponseWriter{}, req)
+// errorReplyMsg builds the reply for a message which could not be dispatched, with the same status headers as
+// replyMsg and a JSON body explaining the error.
+func errorReplyMsg(status int, reason string, err error) *nats.Msg {
+	// marshalling a struct of strings cannot fail.
+	body, _ := json.Marshal(dispatchError{Error: reason, Detail: err.Error()})
+	header := nats.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-NatsBridge-Status", strconv.Itoa(status))
+	header.Set("Nats-Service-Error-Code", strconv.Itoa(status))
+	header.Set("Nats-Service-Error", reason)
+	return &nats.Msg{
+		Header: header,
+		Data:   body,
+	}
+}
+
+// replyMsg converts the recorded HTTP response to the NATS reply. The HTTP status is always set as
+// X-NatsBridge-Status header; non-2xx statuses are additionally reported as NATS service error, which
+// nats_request converts back to the HTTP status.
+func replyMsg(rec *httptest.ResponseRecorder) *nats.Msg {
+	header := nats.Header(rec.Header())
+	header.Set("X-NatsBridge-Status", strconv.Itoa(rec.Code))
+	if rec.Code < 200 || rec.Code > 299 {
+		header.Set("Nats-Service-Error-Code", strconv.Itoa(rec.Code))
+		header.Set("Nats-Service-Error", http.StatusText(rec.Code))
+	}
+	return &nats.Msg{
+		Header: header,
+		Data:   rec.Body.Bytes(),
+	}
 }
 
 func (s *Subscribe) matchServer(servers map[string]*caddyhttp.Server, req *http.Request) (*caddyhttp.Server, error) {
@@ -178,16 +592,23 @@ func (s *Subscribe) prepareRequest(method string, rawURL string, body io.Reader,
 	}
 
 	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
 	if header != nil {
-		req.Header = http.Header(header)
+		// the message headers must not be modified, f.e. when the message is dispatched again.
+		req.Header = http.Header(header).Clone()
 	}
 
 	req.RequestURI = u.Path
 	req.RemoteAddr = s.conn.ConnectedAddr()
 	//TODO: make User-Agent configurable
 	req.Header.Add("User-Agent", "caddy-nats")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
 
-	return req, err
+	return req, nil
 }
 
 var (
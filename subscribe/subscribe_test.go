@@ -218,6 +218,36 @@ func TestSubscribeRequestToNats(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			description: "request, HTTP status is propagated to the response",
+			sendNatsRequest: func(nc *nats.Conn) error {
+				resp, err := nc.Request("foo", []byte("paylod"), 1*time.Second)
+				if err != nil {
+					return err
+				}
+				if status := resp.Header.Get("X-NatsBridge-Status"); status != "404" {
+					return fmt.Errorf("X-NatsBridge-Status does not match. Expected: 404. Actual: %s", status)
+				}
+				if code := resp.Header.Get("Nats-Service-Error-Code"); code != "404" {
+					return fmt.Errorf("Nats-Service-Error-Code does not match. Expected: 404. Actual: %s", code)
+				}
+				return nil
+			},
+			GlobalNatsCaddyfileSnippet: `
+				subscribe foo POST http://localhost:8889/test/something
+			`,
+			CaddyfileSnippet: func(svr *httptest.Server) string {
+				return fmt.Sprintf(`
+					route /test/* {
+						reverse_proxy %s
+					}
+				`, svr.URL)
+			},
+			handleHttp: func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusNotFound)
+				return nil
+			},
+		},
 		// WILDCARDS!!
 	}
 
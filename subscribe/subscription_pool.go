@@ -0,0 +1,68 @@
+package subscribe
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// subPool keeps NATS subscriptions alive across config reloads, as long as the connection and the handler
+// configuration did not change. This way, there is no window in which a queue group member is missing.
+var subPool = caddy.NewUsagePool()
+
+// pooledSubscription is a NATS subscription shared between configs.
+type pooledSubscription struct {
+	sub *nats.Subscription
+	// stopFetch stops fetching messages of a JetStream pull consumer; nil for all other subscriptions.
+	stopFetch context.CancelFunc
+	fetchDone chan struct{}
+
+	mu sync.Mutex
+	// targets are all handlers currently using the subscription, in the order they were started.
+	targets []*Subscribe
+	// target is the handler of the most recently started config, which processes the messages.
+	target atomic.Pointer[Subscribe]
+}
+
+func (ps *pooledSubscription) handle(msg *nats.Msg) {
+	ps.target.Load().dispatch(msg)
+}
+
+func (ps *pooledSubscription) addTarget(s *Subscribe) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.targets = append(ps.targets, s)
+	ps.target.Store(s)
+}
+
+// removeTarget hands the subscription back to the previous handler; f.e. if a config reload failed and the
+// old config keeps running.
+func (ps *pooledSubscription) removeTarget(s *Subscribe) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.targets = slices.DeleteFunc(ps.targets, func(t *Subscribe) bool { return t == s })
+	if len(ps.targets) > 0 {
+		ps.target.Store(ps.targets[len(ps.targets)-1])
+	}
+}
+
+// startFetching fetches the messages of a JetStream pull consumer in the background, until Destruct is called.
+func (ps *pooledSubscription) startFetching(batch int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ps.stopFetch = cancel
+	ps.fetchDone = make(chan struct{})
+	go ps.fetch(ctx, batch)
+}
+
+func (ps *pooledSubscription) Destruct() error {
+	if ps.stopFetch != nil {
+		// messages which were already fetched are still dispatched; the rest is redelivered by the server.
+		ps.stopFetch()
+		<-ps.fetchDone
+	}
+	return ps.sub.Drain()
+}